@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	AuthClientSecretBasic = "client_secret_basic"
+	AuthClientSecretPost  = "client_secret_post"
+	AuthPrivateKeyJWT     = "private_key_jwt"
+	AuthTLSClient         = "tls_client_auth"
+	AuthSelfSignedTLS     = "self_signed_tls_client_auth"
+
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+var supportedGrants = map[string]bool{
+	GrantAuthorizationCode: true,
+	GrantRefreshToken:      true,
+	GrantTokenExchange:     true,
+}
+
+var supportedClaims = map[string]bool{
+	"phone_number": true, "phone_number_verified": true,
+	"telco": true, "mcc": true, "mnc": true, "country": true,
+	"amr": true, "acr": true, "auth_time": true,
+}
+
+type Client struct {
+	ClientID          string        `yaml:"client_id"`
+	SecretHashes      []string      `yaml:"client_secret_hashes"`
+	JWKSFile          string        `yaml:"jwks_file"`
+	AuthMethod        string        `yaml:"token_endpoint_auth_method"`
+	RedirectURIs      []string      `yaml:"redirect_uris"`
+	GrantTypes        []string      `yaml:"grant_types"`
+	Audience          []string      `yaml:"audience"`
+	Claims            []string      `yaml:"claims"`
+	LegacyExtra       bool          `yaml:"legacy_extra"`
+	TokenTTL          time.Duration `yaml:"token_ttl"`
+	RevokeSubjects    bool          `yaml:"revoke_subjects"`
+	ExchangeAudiences []string      `yaml:"exchange_audiences"`
+	TLSSubjectDN      string        `yaml:"tls_client_auth_subject_dn"`
+	TLSSANDNS         string        `yaml:"tls_client_auth_san_dns"`
+	JWKS              []byte        `yaml:"-"`
+}
+
+func loadClients(path string) ([]Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading clients: %w", err)
+	}
+	var raw struct {
+		Clients []Client `yaml:"clients"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing clients: %w", err)
+	}
+
+	seen := make(map[string]bool, len(raw.Clients))
+	for i, c := range raw.Clients {
+		if c.ClientID == "" {
+			return nil, fmt.Errorf("client #%d: client_id is required", i)
+		}
+		if seen[c.ClientID] {
+			return nil, fmt.Errorf("client %s is listed twice", c.ClientID)
+		}
+		seen[c.ClientID] = true
+
+		if c.AuthMethod == "" {
+			c.AuthMethod = AuthClientSecretBasic
+		}
+		if len(c.GrantTypes) == 0 {
+			c.GrantTypes = []string{GrantAuthorizationCode}
+		}
+		for _, grant := range c.GrantTypes {
+			if !supportedGrants[grant] {
+				return nil, fmt.Errorf("client %s: unsupported grant type %q", c.ClientID, grant)
+			}
+		}
+		if c.TokenTTL < 0 {
+			return nil, fmt.Errorf("client %s: token_ttl must not be negative", c.ClientID)
+		}
+		if slices.Contains(c.GrantTypes, GrantTokenExchange) && len(c.ExchangeAudiences) == 0 {
+			return nil, fmt.Errorf("client %s: exchange_audiences is required for token exchange", c.ClientID)
+		}
+		for _, claim := range c.Claims {
+			if !supportedClaims[claim] {
+				return nil, fmt.Errorf("client %s: unsupported claim %q", c.ClientID, claim)
+			}
+		}
+		switch c.AuthMethod {
+		case AuthClientSecretBasic, AuthClientSecretPost:
+			if len(c.SecretHashes) == 0 {
+				return nil, fmt.Errorf("client %s: client_secret_hashes is required for %s", c.ClientID, c.AuthMethod)
+			}
+		case AuthTLSClient:
+			if c.TLSSubjectDN == "" && c.TLSSANDNS == "" {
+				return nil, fmt.Errorf("client %s: tls_client_auth_subject_dn or tls_client_auth_san_dns is required for %s", c.ClientID, c.AuthMethod)
+			}
+		case AuthPrivateKeyJWT, AuthSelfSignedTLS:
+			if c.JWKSFile == "" {
+				return nil, fmt.Errorf("client %s: jwks_file is required for %s", c.ClientID, c.AuthMethod)
+			}
+			jwks, err := os.ReadFile(c.JWKSFile)
+			if err != nil {
+				return nil, fmt.Errorf("client %s: %w", c.ClientID, err)
+			}
+			c.JWKS = jwks
+		default:
+			return nil, fmt.Errorf("client %s: unsupported token_endpoint_auth_method %q", c.ClientID, c.AuthMethod)
+		}
+		raw.Clients[i] = c
+	}
+	return raw.Clients, nil
+}
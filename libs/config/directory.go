@@ -0,0 +1,288 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DirectoryVersion is the current prefix map format: telcos are declared once under "telcos" and
+// "prefixes" maps each dial prefix to a telco name. Files without a version use the original layout,
+// where every prefix holds a full telco entry.
+const DirectoryVersion = 2
+
+type directoryFile struct {
+	Version  int                       `yaml:"version"`
+	Telcos   map[string]directoryEntry `yaml:"telcos"`
+	Prefixes yaml.Node                 `yaml:"prefixes"`
+}
+
+// directoryEntry is a version 2 telco. Endpoints and credentials may be grouped; flat keys still work.
+type directoryEntry struct {
+	Telco     `yaml:",inline"`
+	Endpoints struct {
+		BaseURL      string `yaml:"base_url"`
+		AuthorizeURL string `yaml:"authorize_url"`
+		Issuer       string `yaml:"issuer"`
+	} `yaml:"endpoints"`
+	Credentials struct {
+		ClientID     string `yaml:"client_id"`
+		ClientSecret string `yaml:"client_secret"`
+	} `yaml:"credentials"`
+}
+
+// LoadPrefixMap reads and validates the prefix map at path, resolving each telco's credential env vars.
+// It is used at startup and on every reload, so a bad file is rejected as a whole.
+func LoadPrefixMap(path string) (map[string]Telco, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading prefix map: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("prefix map file is empty")
+	}
+	var raw directoryFile
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing prefix map: %w", err)
+	}
+
+	var prefixes map[string]Telco
+	switch raw.Version {
+	case 0, 1:
+		prefixes, err = legacyPrefixes(raw)
+	case DirectoryVersion:
+		prefixes, err = directoryPrefixes(raw)
+	default:
+		return nil, fmt.Errorf("prefix map version %d is not supported", raw.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("prefix map has no prefixes")
+	}
+	for prefix := range prefixes {
+		if !validPrefix(prefix) {
+			return nil, fmt.Errorf("prefix %q must be a non-empty string of digits", prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+func legacyPrefixes(raw directoryFile) (map[string]Telco, error) {
+	if len(raw.Telcos) > 0 {
+		return nil, fmt.Errorf("prefix map declares telcos without version: %d", DirectoryVersion)
+	}
+	var entries map[string]Telco
+	if err := raw.Prefixes.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("parsing prefix map: %w", err)
+	}
+	for prefix, telco := range entries {
+		resolved, err := resolveTelco(prefix, telco)
+		if err != nil {
+			return nil, err
+		}
+		entries[prefix] = resolved
+	}
+	return entries, nil
+}
+
+func directoryPrefixes(raw directoryFile) (map[string]Telco, error) {
+	telcos := make(map[string]Telco, len(raw.Telcos))
+	for name, entry := range raw.Telcos {
+		telco := entry.flatten()
+		if telco.Name == "" {
+			telco.Name = name
+		}
+		resolved, err := resolveTelco(name, telco)
+		if err != nil {
+			return nil, err
+		}
+		telcos[name] = resolved
+	}
+
+	var refs map[string]string
+	if err := raw.Prefixes.Decode(&refs); err != nil {
+		return nil, fmt.Errorf("parsing prefix map: prefixes must map to telco names: %w", err)
+	}
+	prefixes := make(map[string]Telco, len(refs))
+	used := make(map[string]bool)
+	for prefix, name := range refs {
+		telco, ok := telcos[name]
+		if !ok {
+			return nil, fmt.Errorf("prefix %s references unknown telco %q", prefix, name)
+		}
+		prefixes[prefix] = telco
+		used[name] = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(telcos)) {
+		if !used[name] {
+			return nil, fmt.Errorf("telco %s has no prefixes", name)
+		}
+	}
+	return prefixes, nil
+}
+
+func (e directoryEntry) flatten() Telco {
+	t := e.Telco
+	for dst, src := range map[*string]string{
+		&t.BaseURL:      e.Endpoints.BaseURL,
+		&t.AuthorizeURL: e.Endpoints.AuthorizeURL,
+		&t.Issuer:       e.Endpoints.Issuer,
+		&t.ClientID:     e.Credentials.ClientID,
+		&t.ClientSecret: e.Credentials.ClientSecret,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+	return t
+}
+
+// resolveTelco fills in defaults and credentials for the telco labelled label (its prefix or name).
+func resolveTelco(label string, telco Telco) (Telco, error) {
+	if telco.BaseURL == "" {
+		return Telco{}, fmt.Errorf("telco %s base_url is required", label)
+	}
+	if telco.MCC != "" && !digits(telco.MCC, 3, 3) {
+		return Telco{}, fmt.Errorf("telco %s mcc must be 3 digits", label)
+	}
+	if telco.MNC != "" && !digits(telco.MNC, 2, 3) {
+		return Telco{}, fmt.Errorf("telco %s mnc must be 2 or 3 digits", label)
+	}
+	if telco.Country != "" && (len(telco.Country) != 2 || strings.ToUpper(telco.Country) != telco.Country) {
+		return Telco{}, fmt.Errorf("telco %s country must be an ISO 3166-1 alpha-2 code", label)
+	}
+	cid, err := require(telco.ClientID)
+	if err != nil {
+		return Telco{}, fmt.Errorf("missing env for telco %s client_id: %w", label, err)
+	}
+	secret, err := require(telco.ClientSecret)
+	if err != nil {
+		return Telco{}, fmt.Errorf("missing env for telco %s client_secret: %w", label, err)
+	}
+	telco.ClientID = cid
+	telco.ClientSecret = secret
+	if telco.Issuer == "" {
+		telco.Issuer = telco.BaseURL
+	}
+	if telco.AuthorizeURL == "" {
+		telco.AuthorizeURL = telco.BaseURL + "/authorize"
+	}
+	if len(telco.Audience) == 0 {
+		telco.Audience = []string{cid}
+	}
+	if telco.Leeway < 0 {
+		return Telco{}, fmt.Errorf("telco %s leeway must not be negative", label)
+	}
+	if telco.Leeway == 0 {
+		telco.Leeway = DefaultLeeway
+	}
+	if telco.SubjectClaim == "" {
+		telco.SubjectClaim = DefaultSubjectClaim
+	}
+	if telco.TokenTTL < 0 {
+		return Telco{}, fmt.Errorf("telco %s token_ttl must not be negative", label)
+	}
+	if telco.ACR == "" {
+		telco.ACR = DefaultACR
+	}
+	for _, alg := range telco.Algorithms {
+		if !supportedAlgorithms[alg] {
+			return Telco{}, fmt.Errorf("telco %s: unsupported algorithm %q", label, alg)
+		}
+	}
+	res, err := resolveResilience(telco.Resilience)
+	if err != nil {
+		return Telco{}, fmt.Errorf("telco %s resilience: %w", label, err)
+	}
+	telco.Resilience = res
+	return telco, nil
+}
+
+func resolveResilience(r Resilience) (Resilience, error) {
+	switch {
+	case r.Timeout < 0 || r.Timeout > MaxTelcoTimeout:
+		return r, fmt.Errorf("timeout must be between 0 and %s", MaxTelcoTimeout)
+	case r.RateLimit < 0:
+		return r, fmt.Errorf("rate_limit must not be negative")
+	case r.RateBurst < 0:
+		return r, fmt.Errorf("rate_burst must not be negative")
+	case r.JWKSTTL < 0:
+		return r, fmt.Errorf("jwks_ttl must not be negative")
+	case r.Breaker.Timeout < 0:
+		return r, fmt.Errorf("breaker timeout must not be negative")
+	case r.Breaker.Interval < 0:
+		return r, fmt.Errorf("breaker interval must not be negative")
+	}
+	for _, status := range r.Breaker.FailureStatuses {
+		if !validStatusPattern(status) {
+			return r, fmt.Errorf("breaker failure_statuses entry %q must be an HTTP status like 503 or a class like 5xx", status)
+		}
+	}
+	return r.WithDefaults(), nil
+}
+
+// WithDefaults fills every unset setting with its default.
+func (r Resilience) WithDefaults() Resilience {
+	r.Timeout = cmp.Or(r.Timeout, DefaultTelcoTimeout)
+	r.RateLimit = cmp.Or(r.RateLimit, DefaultTelcoRateLimit)
+	r.RateBurst = cmp.Or(r.RateBurst, DefaultTelcoRateBurst)
+	r.JWKSTTL = cmp.Or(r.JWKSTTL, DefaultJWKSTTL)
+	r.Breaker.ConsecutiveFailures = cmp.Or(r.Breaker.ConsecutiveFailures, DefaultBreakerFailures)
+	r.Breaker.Timeout = cmp.Or(r.Breaker.Timeout, DefaultBreakerTimeout)
+	r.Breaker.MaxRequests = cmp.Or(r.Breaker.MaxRequests, DefaultBreakerMaxRequests)
+	r.Breaker.Interval = cmp.Or(r.Breaker.Interval, DefaultBreakerInterval)
+	if len(r.Breaker.FailureStatuses) == 0 {
+		r.Breaker.FailureStatuses = []string{DefaultBreakerStatuses}
+	}
+	return r
+}
+
+// LogValue logs a telco by its identity and endpoint, never its credentials.
+func (t Telco) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("base_url", t.BaseURL)}
+	for _, a := range []slog.Attr{
+		slog.String("name", t.Name),
+		slog.String("mcc", t.MCC),
+		slog.String("mnc", t.MNC),
+		slog.String("country", t.Country),
+	} {
+		if a.Value.String() != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	if len(t.Metadata) > 0 {
+		attrs = append(attrs, slog.Any("metadata", t.Metadata))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func validStatusPattern(s string) bool {
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' {
+		return false
+	}
+	return s[1:] == "xx" || digits(s[1:], 2, 2)
+}
+
+func validPrefix(prefix string) bool {
+	return digits(prefix, 1, 15)
+}
+
+func digits(s string, minLen, maxLen int) bool {
+	if len(s) < minLen || len(s) > maxLen {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeDirectory(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prefix_map.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write prefix map: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrefixMap_Formats(t *testing.T) {
+	t.Setenv("P_ID", "partner-id")
+	t.Setenv("P_SECRET", "partner-secret")
+
+	legacy := `prefixes:
+  "97254":
+    name: partner
+    mcc: "425"
+    mnc: "01"
+    country: IL
+    base_url: http://partner
+    client_id: P_ID
+    client_secret: P_SECRET
+  "97255":
+    name: partner
+    mcc: "425"
+    mnc: "01"
+    country: IL
+    base_url: http://partner
+    client_id: P_ID
+    client_secret: P_SECRET
+`
+	directory := `version: 2
+telcos:
+  partner:
+    mcc: "425"
+    mnc: "01"
+    country: IL
+    metadata: {region: center}
+    resilience:
+      timeout: 2s
+      breaker: {consecutive_failures: 5}
+    endpoints:
+      base_url: http://partner
+    credentials:
+      client_id: P_ID
+      client_secret: P_SECRET
+prefixes:
+  "97254": partner
+  "97255": partner
+`
+	for name, body := range map[string]string{"legacy": legacy, "directory": directory} {
+		prefixes, err := LoadPrefixMap(writeDirectory(t, body))
+		if err != nil {
+			t.Fatalf("%s: LoadPrefixMap: %v", name, err)
+		}
+		if len(prefixes) != 2 {
+			t.Fatalf("%s: got %d prefixes, want 2", name, len(prefixes))
+		}
+		p := prefixes["97255"]
+		if name == "directory" && (p.Resilience.Timeout != 2*time.Second || p.Resilience.Breaker.ConsecutiveFailures != 5) {
+			t.Errorf("directory: resilience = %+v", p.Resilience)
+		}
+		if p.Name != "partner" || p.MCC != "425" || p.MNC != "01" || p.Country != "IL" {
+			t.Errorf("%s: metadata = %+v", name, p)
+		}
+		if p.Resilience.RateBurst != DefaultTelcoRateBurst || p.Resilience.Breaker.Timeout != DefaultBreakerTimeout {
+			t.Errorf("%s: resilience defaults not applied: %+v", name, p.Resilience)
+		}
+		if p.BaseURL != "http://partner" || p.ClientID != "partner-id" || p.ClientSecret != "partner-secret" || p.Issuer != "http://partner" {
+			t.Errorf("%s: endpoints/credentials = %+v", name, p)
+		}
+	}
+}
+
+func TestLoadPrefixMap_Invalid(t *testing.T) {
+	t.Setenv("P_ID", "partner-id")
+	t.Setenv("P_SECRET", "partner-secret")
+	const telco = `    endpoints: {base_url: http://partner}
+    credentials: {client_id: P_ID, client_secret: P_SECRET}
+`
+	cases := map[string]string{
+		"unknown version":   "version: 3\nprefixes: {}\n",
+		"unknown telco":     "version: 2\ntelcos:\n  partner:\n" + telco + "prefixes:\n  \"97254\": golan\n",
+		"unused telco":      "version: 2\ntelcos:\n  partner:\n" + telco + "  golan:\n" + telco + "prefixes:\n  \"97254\": partner\n",
+		"bad prefix":        "version: 2\ntelcos:\n  partner:\n" + telco + "prefixes:\n  \"+972\": partner\n",
+		"bad mcc":           "version: 2\ntelcos:\n  partner:\n    mcc: \"42\"\n" + telco + "prefixes:\n  \"97254\": partner\n",
+		"telcos no version": "telcos:\n  partner:\n" + telco + "prefixes:\n  \"97254\": partner\n",
+		"missing base_url":  "prefixes:\n  \"97254\": {client_id: P_ID, client_secret: P_SECRET}\n",
+		"negative rate":     "version: 2\ntelcos:\n  partner:\n    resilience: {rate_limit: -1}\n" + telco + "prefixes:\n  \"97254\": partner\n",
+		"huge timeout":      "version: 2\ntelcos:\n  partner:\n    resilience: {timeout: 1h}\n" + telco + "prefixes:\n  \"97254\": partner\n",
+		"bad status":        "version: 2\ntelcos:\n  partner:\n    resilience: {breaker: {failure_statuses: [4x9]}}\n" + telco + "prefixes:\n  \"97254\": partner\n",
+	}
+	for name, body := range cases {
+		if _, err := LoadPrefixMap(writeDirectory(t, body)); err == nil {
+			t.Errorf("%s: LoadPrefixMap accepted the file", name)
+		} else if strings.Contains(err.Error(), "partner-secret") {
+			t.Errorf("%s: error leaks the secret: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type SigningKeyConfig struct {
+	KeyID      string    `yaml:"kid"`
+	Algorithm  string    `yaml:"alg"`
+	PEMFile    string    `yaml:"pem_file"`
+	PEMEnv     string    `yaml:"pem_env"`
+	ActiveFrom time.Time `yaml:"active_from"`
+	RetireAt   time.Time `yaml:"retire_at"`
+	PEM        []byte    `yaml:"-"`
+}
+
+func loadSigningKeys(path string) ([]SigningKeyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing keys: %w", err)
+	}
+	var raw struct {
+		Keys []SigningKeyConfig `yaml:"keys"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing signing keys: %w", err)
+	}
+	if len(raw.Keys) == 0 {
+		return nil, fmt.Errorf("signing keys file %s lists no keys", path)
+	}
+
+	for i, key := range raw.Keys {
+		if key.KeyID == "" || key.Algorithm == "" {
+			return nil, fmt.Errorf("signing key #%d: kid and alg are required", i)
+		}
+		if !key.RetireAt.IsZero() && !key.RetireAt.After(key.ActiveFrom) {
+			return nil, fmt.Errorf("signing key %s: retire_at must be after active_from", key.KeyID)
+		}
+		switch {
+		case key.PEMFile != "" && key.PEMEnv != "":
+			return nil, fmt.Errorf("signing key %s: set only one of pem_file and pem_env", key.KeyID)
+		case key.PEMFile != "":
+			pem, err := os.ReadFile(key.PEMFile)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %s: %w", key.KeyID, err)
+			}
+			key.PEM = pem
+		case key.PEMEnv != "":
+			pem, err := require(key.PEMEnv)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %s: %w", key.KeyID, err)
+			}
+			key.PEM = []byte(pem)
+		default:
+			return nil, fmt.Errorf("signing key %s: pem_file or pem_env is required", key.KeyID)
+		}
+		raw.Keys[i] = key
+	}
+	return raw.Keys, nil
+}
@@ -1,11 +1,15 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -15,18 +19,126 @@ const (
 	PortKey       = "PORT"
 	PrefixMapPath = "PREFIX_MAP_PATH"
 	SigningKey    = "SIGNING_KEY"
+	SigningKeys   = "SIGNING_KEYS_PATH"
+	IssuerURL     = "BROKER_ISSUER_URL"
+	ClientsPath   = "CLIENTS_PATH"
+	TokenTTL      = "BROKER_TOKEN_TTL"
+	TokenAudience = "BROKER_TOKEN_AUDIENCE"
+	RefreshTTL    = "REFRESH_TOKEN_TTL"
+	RefreshStore  = "REFRESH_STORE_PATH"
+	ExchangeTTL   = "TOKEN_EXCHANGE_TTL"
+	TLSCertFile   = "TLS_CERT_FILE"
+	TLSKeyFile    = "TLS_KEY_FILE"
+	TLSClientCA   = "TLS_CLIENT_CA_FILE"
+	ErrorDebug    = "ERROR_DEBUG"
+	AdminAddr     = "ADMIN_ADDR"
+
+	DefaultIssuer      = "sim-broker"
+	DefaultTokenTTL    = 15 * time.Minute
+	DefaultRefreshTTL  = 30 * 24 * time.Hour
+	DefaultExchangeTTL = 5 * time.Minute
+
+	DefaultLeeway = 30 * time.Second
+
+	DefaultSubjectClaim = "sub"
+	DefaultACR          = "urn:sim-broker:acr:sim"
 )
 
+const (
+	DefaultTelcoTimeout       = 5 * time.Second
+	DefaultTelcoRateLimit     = 5
+	DefaultTelcoRateBurst     = 10
+	DefaultJWKSTTL            = 10 * time.Minute
+	DefaultBreakerFailures    = 3
+	DefaultBreakerTimeout     = 30 * time.Second
+	DefaultBreakerMaxRequests = 1
+	DefaultBreakerInterval    = time.Minute
+	DefaultBreakerStatuses    = "5xx"
+	MaxTelcoTimeout           = time.Minute
+)
+
+var supportedAlgorithms = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
 type Telco struct {
-	BaseURL      string `yaml:"base_url"`
-	ClientID     string `yaml:"client_id"`
-	ClientSecret string `yaml:"client_secret"`
+	Name          string        `yaml:"name"`
+	MCC           string        `yaml:"mcc"`
+	MNC           string        `yaml:"mnc"`
+	Country       string        `yaml:"country"`
+	ACR           string        `yaml:"acr"`
+	BaseURL       string        `yaml:"base_url"`
+	AuthorizeURL  string        `yaml:"authorize_url"`
+	ClientID      string        `yaml:"client_id"`
+	ClientSecret  string        `yaml:"client_secret"`
+	Issuer        string        `yaml:"issuer"`
+	Audience      []string      `yaml:"audience"`
+	Leeway        time.Duration `yaml:"leeway"`
+	Algorithms    []string      `yaml:"algorithms"`
+	SubjectClaim  string        `yaml:"subject_claim"`
+	TokenTTL      time.Duration `yaml:"token_ttl"`
+	TokenAudience []string      `yaml:"token_audience"`
+
+	Metadata   map[string]string `yaml:"metadata"`
+	Resilience Resilience        `yaml:"resilience"`
+}
+
+// Resilience tunes how the broker calls one telco. RateLimit is in requests per second; zero values take
+// the defaults.
+type Resilience struct {
+	Timeout   time.Duration `yaml:"timeout"`
+	RateLimit float64       `yaml:"rate_limit"`
+	RateBurst int           `yaml:"rate_burst"`
+	JWKSTTL   time.Duration `yaml:"jwks_ttl"`
+	Breaker   Breaker       `yaml:"breaker"`
+}
+
+// Breaker configures the circuit breaker in front of a telco: it opens after ConsecutiveFailures,
+// stays open for Timeout, then lets MaxRequests probes through. Interval resets the counts while closed.
+// Timeouts and connection errors are always failures; FailureStatuses lists the telco HTTP statuses that
+// are too, as exact codes ("429") or classes ("5xx").
+type Breaker struct {
+	ConsecutiveFailures uint32        `yaml:"consecutive_failures"`
+	Timeout             time.Duration `yaml:"timeout"`
+	MaxRequests         uint32        `yaml:"max_requests"`
+	Interval            time.Duration `yaml:"interval"`
+	FailureStatuses     []string      `yaml:"failure_statuses"`
+}
+
+// IsFailure reports whether a telco response with this HTTP status counts against the breaker.
+func (b Breaker) IsFailure(status int) bool {
+	code := strconv.Itoa(status)
+	for _, s := range b.FailureStatuses {
+		if s == code || (strings.HasSuffix(s, "xx") && s[0] == code[0]) {
+			return true
+		}
+	}
+	return false
 }
 
 type BrokerConfig struct {
-	PrefixMap  map[string]Telco
-	SigningKey string
-	ListenAddr string
+	PrefixMap     map[string]Telco
+	PrefixMapPath string
+	SigningKey    string
+	SigningKeys   []SigningKeyConfig
+	ListenAddr    string
+	AdminAddr     string
+	Issuer        string
+	Audience      []string
+	TokenTTL      time.Duration
+	RefreshTTL    time.Duration
+	ExchangeTTL   time.Duration
+	RefreshPath   string
+	Clients       []Client
+
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+
+	DebugErrors bool
 }
 
 type TelcoConfig struct {
@@ -87,49 +199,108 @@ func LoadBrokerConfig() (*BrokerConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(path)
+	prefixes, err := LoadPrefixMap(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading prefix map: %w", err)
+		return nil, err
 	}
-	if len(data) == 0 {
-		return nil, fmt.Errorf("prefix map file is empty")
+	var keys []SigningKeyConfig
+	if keysPath := os.Getenv(SigningKeys); keysPath != "" {
+		keys, err = loadSigningKeys(keysPath)
+		if err != nil {
+			return nil, err
+		}
 	}
-	var raw struct {
-		Prefixes map[string]Telco `yaml:"prefixes"`
+	skey := os.Getenv(SigningKey)
+	if skey == "" && len(keys) == 0 {
+		return nil, fmt.Errorf("environment variable %s or %s is required", SigningKeys, SigningKey)
 	}
-	if err := yaml.Unmarshal(data, &raw); err != nil {
-		return nil, fmt.Errorf("parsing prefix map: %w", err)
+	port, err := require(PortKey)
+	if err != nil {
+		return nil, err
 	}
-
-	for prefix, telco := range raw.Prefixes {
-		cid, err := require(telco.ClientID)
-		if err != nil {
-			return nil, fmt.Errorf("missing env for telco %s client_id: %w", prefix, err)
-		}
-		secret, err := require(telco.ClientSecret)
-		if err != nil {
-			return nil, fmt.Errorf("missing env for telco %s client_secret: %w", prefix, err)
-		}
-		telco.ClientID = cid
-		telco.ClientSecret = secret
-		raw.Prefixes[prefix] = telco
+	clientsPath, err := require(ClientsPath)
+	if err != nil {
+		return nil, err
 	}
-	skey, err := require(SigningKey)
+	clients, err := loadClients(clientsPath)
 	if err != nil {
 		return nil, err
 	}
-	port, err := require(PortKey)
+	issuer := os.Getenv(IssuerURL)
+	if issuer == "" {
+		issuer = DefaultIssuer
+	}
+	if u, err := url.Parse(issuer); env == EnvProd && (err != nil || u.Scheme != "https" || u.Host == "") {
+		return nil, fmt.Errorf("environment variable %s must be an https URL when %s=%s", IssuerURL, EnvKey, EnvProd)
+	}
+	ttl, err := durationEnv(TokenTTL, DefaultTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshTTL, err := durationEnv(RefreshTTL, DefaultRefreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	exchangeTTL, err := durationEnv(ExchangeTTL, DefaultExchangeTTL)
 	if err != nil {
 		return nil, err
 	}
+	tlsCert, tlsKey, clientCA := os.Getenv(TLSCertFile), os.Getenv(TLSKeyFile), os.Getenv(TLSClientCA)
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, fmt.Errorf("environment variables %s and %s must be set together", TLSCertFile, TLSKeyFile)
+	}
+	if clientCA != "" && tlsCert == "" {
+		return nil, fmt.Errorf("environment variable %s requires %s", TLSClientCA, TLSCertFile)
+	}
+	debugErrors, err := strconv.ParseBool(cmp.Or(os.Getenv(ErrorDebug), "false"))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s must be a boolean", ErrorDebug)
+	}
+	if debugErrors && env != EnvDev {
+		return nil, fmt.Errorf("environment variable %s is only allowed when %s=%s", ErrorDebug, EnvKey, EnvDev)
+	}
+	var audience []string
+	for _, aud := range strings.Split(os.Getenv(TokenAudience), ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			audience = append(audience, aud)
+		}
+	}
 
 	return &BrokerConfig{
-		PrefixMap:  raw.Prefixes,
-		SigningKey: skey,
-		ListenAddr: port,
+		PrefixMap:     prefixes,
+		PrefixMapPath: path,
+		SigningKey:    skey,
+		SigningKeys:   keys,
+		ListenAddr:    port,
+		AdminAddr:     os.Getenv(AdminAddr),
+		Issuer:        issuer,
+		Audience:      audience,
+		TokenTTL:      ttl,
+		RefreshTTL:    refreshTTL,
+		ExchangeTTL:   exchangeTTL,
+		RefreshPath:   os.Getenv(RefreshStore),
+		Clients:       clients,
+
+		TLSCertFile:  tlsCert,
+		TLSKeyFile:   tlsKey,
+		ClientCAFile: clientCA,
+
+		DebugErrors: debugErrors,
 	}, nil
 }
 
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("environment variable %s must be a positive duration", key)
+	}
+	return d, nil
+}
+
 func require(key string) (string, error) {
 	if v, ok := os.LookupEnv(key); ok && v != "" {
 		return v, nil
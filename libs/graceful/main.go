@@ -50,8 +50,14 @@ func StartServer(server *http.Server, timeout time.Duration, logger *slog.Logger
 
 	errCh := make(chan error, 1)
 	go func() {
-		logger.Info("Server listening", "address", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("Server listening", "address", server.Addr, "tls", server.TLSConfig != nil)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errCh <- err
 		}
 		close(errCh)
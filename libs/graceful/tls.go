@@ -0,0 +1,82 @@
+package graceful
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %s has no certificates", path)
+	}
+	return pool, nil
+}
+
+// ServerTLS loads the server key pair. With clientCAs set the server also asks for a client certificate:
+// one that is sent must either chain to clientCAs or be self-signed, leaving it to the application to
+// decide which certificate a given client may use (RFC 8705 allows both). Clients that send no
+// certificate are let through so they can still authenticate another way.
+func ServerTLS(certFile, keyFile string, clientCAs *x509.CertPool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAs != nil {
+		cfg.ClientAuth = tls.RequestClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return nil
+			}
+			return verifyClientCert(cs.PeerCertificates, clientCAs)
+		}
+	}
+	return cfg, nil
+}
+
+// VerifyClientChain checks that the client certificate chain is anchored in roots and usable for
+// client authentication.
+func VerifyClientChain(chain []*x509.Certificate, roots *x509.CertPool) error {
+	if len(chain) == 0 {
+		return errors.New("no client certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func verifyClientCert(chain []*x509.Certificate, roots *x509.CertPool) error {
+	if VerifyClientChain(chain, roots) == nil {
+		return nil
+	}
+	leaf := chain[0]
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return errors.New("client certificate is expired or not yet valid")
+	}
+	if bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil {
+		return nil
+	}
+	return errors.New("client certificate is neither issued by a trusted CA nor self-signed")
+}
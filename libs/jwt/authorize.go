@@ -0,0 +1,101 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+const (
+	REDIRECT_URI          = "redirect_uri"
+	STATE                 = "state"
+	CODE_CHALLENGE        = "code_challenge"
+	CODE_CHALLENGE_METHOD = "code_challenge_method"
+	CODE_VERIFIER         = "code_verifier"
+	MOCK_CODE_TTL         = time.Minute
+)
+
+type mockCode struct {
+	phone       string
+	challenge   string
+	redirectURI string
+	expiresAt   time.Time
+}
+
+var (
+	mockCodesMu sync.Mutex
+	mockCodes   = make(map[string]mockCode)
+)
+
+// AuthorizeHandler is the mock telco's authorization endpoint. It stands in for network-based SIM
+// authentication: the subscriber named by login_hint is treated as authenticated and a code is issued
+// for them straight away. The phone is bound to the code here; /token only ever reports that phone.
+func AuthorizeHandler(expectedID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "authorization requests must use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get(CLIENT_ID) != expectedID {
+			utilities.WriteJSONError(w, utilities.UnauthorizedClient, "unknown client_id", http.StatusBadRequest)
+			return
+		}
+		redirect, err := url.Parse(q.Get(REDIRECT_URI))
+		if err != nil || !redirect.IsAbs() {
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "redirect_uri must be an absolute URL", http.StatusBadRequest)
+			return
+		}
+		phone := q.Get(LOGIN_HINT)
+		if phone == "" || q.Get(CODE_CHALLENGE) == "" || q.Get(CODE_CHALLENGE_METHOD) != "S256" {
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "login_hint and an S256 code_challenge are required", http.StatusBadRequest)
+			return
+		}
+
+		code := NewID()
+		now := time.Now()
+		mockCodesMu.Lock()
+		for k, c := range mockCodes {
+			if now.After(c.expiresAt) {
+				delete(mockCodes, k)
+			}
+		}
+		mockCodes[code] = mockCode{
+			phone:       phone,
+			challenge:   q.Get(CODE_CHALLENGE),
+			redirectURI: redirect.String(),
+			expiresAt:   now.Add(MOCK_CODE_TTL),
+		}
+		mockCodesMu.Unlock()
+
+		params := redirect.Query()
+		params.Set(CODE, code)
+		if state := q.Get(STATE); state != "" {
+			params.Set(STATE, state)
+		}
+		redirect.RawQuery = params.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	}
+}
+
+func takeMockCode(code string) (mockCode, bool) {
+	mockCodesMu.Lock()
+	defer mockCodesMu.Unlock()
+	c, ok := mockCodes[code]
+	delete(mockCodes, code)
+	if !ok || time.Now().After(c.expiresAt) {
+		return mockCode{}, false
+	}
+	return c, true
+}
+
+func checkMockPKCE(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
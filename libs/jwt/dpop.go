@@ -0,0 +1,172 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	Jwt "github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	DPOP           = "DPoP"
+	DPOP_TYPE      = "dpop+jwt"
+	CONFIRMATION   = "cnf"
+	JWK_THUMBPRINT = "jkt"
+	CHECK_DPOP     = "dpop"
+
+	DefaultDPoPMaxAge = time.Minute
+	DefaultDPoPLeeway = 5 * time.Second
+)
+
+var (
+	ErrInvalidDPoP  = errors.New("DPoP proof is invalid")
+	ErrDPoPReplayed = errors.New("DPoP proof was already used")
+	ErrDPoPBinding  = errors.New("token is not bound to the DPoP proof key")
+)
+
+// ReplayChecker records a proof identifier until expiresAt and reports whether it was already seen.
+type ReplayChecker interface {
+	Seen(id string, expiresAt time.Time) bool
+}
+
+// DPoPOptions tunes proof verification. Zero values use the defaults and skip replay detection.
+type DPoPOptions struct {
+	MaxAge     time.Duration
+	Leeway     time.Duration
+	Algorithms []string
+	Replay     ReplayChecker
+}
+
+// DPoPProof is a verified RFC 9449 proof. JKT is the SHA-256 thumbprint of the proof key.
+type DPoPProof struct {
+	JKT      string
+	ID       string
+	IssuedAt time.Time
+}
+
+type dpopClaims struct {
+	ID       string           `json:"jti"`
+	Method   string           `json:"htm"`
+	URI      string           `json:"htu"`
+	IssuedAt *Jwt.NumericDate `json:"iat"`
+	ATH      string           `json:"ath"`
+}
+
+// VerifyDPoP checks a DPoP proof for a request with the given method and URI: the proof must be a
+// dpop+jwt signed by the public key it embeds, match htm/htu, be recent, and not be replayed. When
+// accessToken is non-empty the proof's ath must hash it.
+func VerifyDPoP(proof, method, uri, accessToken string, opts DPoPOptions) (*DPoPProof, error) {
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultDPoPMaxAge
+	}
+	if opts.Leeway <= 0 {
+		opts.Leeway = DefaultDPoPLeeway
+	}
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = DefaultAlgorithms
+	}
+
+	sig, err := jose.ParseSigned(proof, asymmetricAlgorithms)
+	if err != nil {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: %v", ErrMalformed, err))
+	}
+	if len(sig.Signatures) != 1 {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: expected one signature", ErrMalformed))
+	}
+	header := sig.Signatures[0].Header
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != DPOP_TYPE {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: typ must be %s", ErrInvalidDPoP, DPOP_TYPE))
+	}
+	if !allowed(header.Algorithm, opts.Algorithms) {
+		return nil, invalid(CHECK_ALG, fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, header.Algorithm))
+	}
+	key := header.JSONWebKey
+	if key == nil || !key.IsPublic() || !key.Valid() {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: jwk header must hold a public key", ErrInvalidDPoP))
+	}
+	payload, err := sig.Verify(key)
+	if err != nil {
+		return nil, invalid(CHECK_SIGNATURE, ErrInvalidSignature)
+	}
+
+	var claims dpopClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: %v", ErrMalformed, err))
+	}
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: jti and iat are required", ErrInvalidDPoP))
+	}
+	if !strings.EqualFold(claims.Method, method) {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: htm does not match %s", ErrInvalidDPoP, method))
+	}
+	if !sameHTU(claims.URI, uri) {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: htu does not match the request", ErrInvalidDPoP))
+	}
+	now := time.Now()
+	iat := claims.IssuedAt.Time()
+	if iat.After(now.Add(opts.Leeway)) || now.Sub(iat) > opts.MaxAge+opts.Leeway {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: iat is outside the accepted window", ErrInvalidDPoP))
+	}
+	if accessToken != "" && claims.ATH != AccessTokenHash(accessToken) {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: ath does not match the access token", ErrInvalidDPoP))
+	}
+
+	thumb, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, invalid(CHECK_DPOP, fmt.Errorf("%w: %v", ErrInvalidDPoP, err))
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumb)
+	if opts.Replay != nil && opts.Replay.Seen(jkt+"|"+claims.ID, iat.Add(opts.MaxAge+opts.Leeway)) {
+		return nil, invalid(CHECK_DPOP, ErrDPoPReplayed)
+	}
+	return &DPoPProof{JKT: jkt, ID: claims.ID, IssuedAt: iat}, nil
+}
+
+// VerifyDPoPBound is for resource servers: after validating a DPoP-bound access token, it checks that
+// the request's proof covers that token and is signed by the key named in its cnf.jkt.
+func VerifyDPoPBound(claims *Payload, accessToken, proof, method, uri string, opts DPoPOptions) error {
+	jkt, ok := ConfirmationJKT(claims)
+	if !ok {
+		return invalid(CHECK_DPOP, fmt.Errorf("%w: token has no cnf.jkt", ErrDPoPBinding))
+	}
+	p, err := VerifyDPoP(proof, method, uri, accessToken, opts)
+	if err != nil {
+		return err
+	}
+	if p.JKT != jkt {
+		return invalid(CHECK_DPOP, ErrDPoPBinding)
+	}
+	return nil
+}
+
+// ConfirmationJKT returns the DPoP key thumbprint a token is bound to, if any.
+func ConfirmationJKT(p *Payload) (string, bool) {
+	return confirmation(p, JWK_THUMBPRINT)
+}
+
+// AccessTokenHash is the DPoP "ath" value: the base64url SHA-256 of the access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sameHTU compares two HTTP URIs without their query and fragment, ignoring scheme and host case.
+func sameHTU(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host) && ua.EscapedPath() == ub.EscapedPath()
+}
@@ -0,0 +1,98 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	Jwt "github.com/go-jose/go-jose/v4/jwt"
+)
+
+type testReplay map[string]bool
+
+func (r testReplay) Seen(id string, _ time.Time) bool {
+	seen := r[id]
+	r[id] = true
+	return seen
+}
+
+func newDPoPProof(t *testing.T, key *ecdsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{
+		EmbedJWK: true,
+	}).WithType(DPOP_TYPE))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	proof, err := Jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("sign proof: %v", err)
+	}
+	return proof
+}
+
+func TestVerifyDPoP(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	const uri = "https://broker.example/token"
+	proof := func(mutate func(map[string]any)) string {
+		claims := map[string]any{"jti": NewID(), "htm": "POST", "htu": uri, "iat": time.Now().Unix()}
+		if mutate != nil {
+			mutate(claims)
+		}
+		return newDPoPProof(t, key, claims)
+	}
+
+	replay := testReplay{}
+	good := proof(nil)
+	p, err := VerifyDPoP(good, "POST", uri+"?x=1", "", DPoPOptions{Replay: replay})
+	if err != nil {
+		t.Fatalf("VerifyDPoP: %v", err)
+	}
+	jwk := jose.JSONWebKey{Key: key.Public()}
+	thumb, _ := jwk.Thumbprint(crypto.SHA256)
+	if p.JKT != base64.RawURLEncoding.EncodeToString(thumb) {
+		t.Errorf("JKT = %q, want the key thumbprint", p.JKT)
+	}
+	if _, err := VerifyDPoP(good, "POST", uri, "", DPoPOptions{Replay: replay}); !errors.Is(err, ErrDPoPReplayed) {
+		t.Errorf("replayed proof error = %v, want ErrDPoPReplayed", err)
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(map[string]any)
+		method string
+	}{
+		{"wrong method", nil, "GET"},
+		{"wrong uri", func(c map[string]any) { c["htu"] = "https://broker.example/revoke" }, "POST"},
+		{"stale", func(c map[string]any) { c["iat"] = time.Now().Add(-time.Hour).Unix() }, "POST"},
+		{"future", func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() }, "POST"},
+		{"no jti", func(c map[string]any) { delete(c, "jti") }, "POST"},
+	}
+	for _, c := range cases {
+		if _, err := VerifyDPoP(proof(c.mutate), c.method, uri, "", DPoPOptions{}); !errors.Is(err, ErrInvalidDPoP) {
+			t.Errorf("%s: error = %v, want ErrInvalidDPoP", c.name, err)
+		}
+	}
+
+	bound := &Payload{Extra: map[string]any{CONFIRMATION: map[string]any{JWK_THUMBPRINT: p.JKT}}}
+	withATH := proof(func(c map[string]any) { c["ath"] = AccessTokenHash("access") })
+	if err := VerifyDPoPBound(bound, "access", withATH, "POST", uri, DPoPOptions{}); err != nil {
+		t.Errorf("VerifyDPoPBound: %v", err)
+	}
+	if err := VerifyDPoPBound(bound, "other", withATH, "POST", uri, DPoPOptions{}); !errors.Is(err, ErrInvalidDPoP) {
+		t.Errorf("ath mismatch error = %v, want ErrInvalidDPoP", err)
+	}
+	otherKey := &Payload{Extra: map[string]any{CONFIRMATION: map[string]any{JWK_THUMBPRINT: "other"}}}
+	if err := VerifyDPoPBound(otherKey, "access", withATH, "POST", uri, DPoPOptions{}); !errors.Is(err, ErrDPoPBinding) {
+		t.Errorf("key mismatch error = %v, want ErrDPoPBinding", err)
+	}
+}
@@ -0,0 +1,49 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	CHECK_HEADER    = "header"
+	CHECK_ALG       = "alg"
+	CHECK_SIGNATURE = "signature"
+	CHECK_EXP       = "exp"
+	CHECK_NBF       = "nbf"
+	CHECK_ISS       = "iss"
+	CHECK_AUD       = "aud"
+	CHECK_REVOKED   = "revoked"
+	CHECK_TOKEN_USE = "token_use"
+)
+
+var (
+	ErrMalformed           = errors.New("token is malformed")
+	ErrAlgorithmNotAllowed = errors.New("signing algorithm is not allowed")
+	ErrUnknownKey          = errors.New("no matching signing key")
+	ErrInvalidSignature    = errors.New("token signature is invalid")
+	ErrExpired             = errors.New("token is expired")
+	ErrMissingExpiry       = errors.New("token has no expiry")
+	ErrNotValidYet         = errors.New("token is not valid yet")
+	ErrInvalidIssuer       = errors.New("token issuer is not trusted")
+	ErrInvalidAudience     = errors.New("token audience does not match")
+	ErrRevoked             = errors.New("token has been revoked")
+	ErrWrongTokenUse       = errors.New("token is not meant for this use")
+)
+
+type ValidationError struct {
+	Check string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s check failed: %v", e.Check, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func invalid(check string, err error) error {
+	return &ValidationError{Check: check, Err: err}
+}
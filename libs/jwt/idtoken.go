@@ -0,0 +1,52 @@
+package jwt
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	SCOPE          = "scope"
+	SCOPE_OPENID   = "openid"
+	NONCE          = "nonce"
+	AT_HASH        = "at_hash"
+	ID_TOKEN       = "id_token"
+	ACCESS_TOKEN   = "access_token"
+	PHONE_VERIFIED = "phone_number_verified"
+)
+
+// HasScope reports whether the space-delimited scope string contains want.
+func HasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// AtHash computes the OIDC "at_hash" of an access token: the left half of its hash, using the hash
+// that matches the token's own signing algorithm.
+func AtHash(accessToken string) (string, error) {
+	sig, err := jose.ParseSigned(accessToken, slices.Concat(asymmetricAlgorithms, hmacAlgorithms))
+	if err != nil {
+		return "", fmt.Errorf("at_hash: %w", err)
+	}
+	var h crypto.Hash
+	switch alg := sig.Signatures[0].Header.Algorithm; {
+	case strings.HasSuffix(alg, "256"):
+		h = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		h = crypto.SHA384
+	case strings.HasSuffix(alg, "512"), alg == string(jose.EdDSA):
+		h = crypto.SHA512
+	default:
+		return "", fmt.Errorf("at_hash: unsupported algorithm %q", alg)
+	}
+	d := h.New()
+	d.Write([]byte(accessToken))
+	sum := d.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
@@ -0,0 +1,35 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestAtHash(t *testing.T) {
+	if err := InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	token, err := Sign("issuer", "sub", []string{"aud"}, time.Minute, nil)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	got, err := AtHash(token)
+	if err != nil {
+		t.Fatalf("AtHash: %v", err)
+	}
+	sum := sha256.Sum256([]byte(token))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:16]); got != want {
+		t.Errorf("AtHash = %q, want %q", got, want)
+	}
+	if _, err := AtHash("not-a-jwt"); err == nil {
+		t.Error("AtHash accepted a malformed token")
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	if !HasScope("openid phone", SCOPE_OPENID) || HasScope("openidx phone", SCOPE_OPENID) || HasScope("", SCOPE_OPENID) {
+		t.Error("HasScope must match whole space-delimited values")
+	}
+}
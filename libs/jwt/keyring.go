@@ -0,0 +1,227 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	KEY_STATE_NEXT     = "next"
+	KEY_STATE_CURRENT  = "current"
+	KEY_STATE_RETIRING = "retiring"
+	KEY_STATE_RETIRED  = "retired"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+type SigningKey struct {
+	KeyID      string
+	Algorithm  string
+	Key        any
+	ActiveFrom time.Time
+	RetireAt   time.Time
+}
+
+type Keyring struct {
+	keys    []SigningKey
+	signers map[string]jose.Signer
+}
+
+func NewKeyring(keys []SigningKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring needs at least one key")
+	}
+	kr := &Keyring{
+		keys:    append([]SigningKey(nil), keys...),
+		signers: make(map[string]jose.Signer, len(keys)),
+	}
+	sort.SliceStable(kr.keys, func(i, j int) bool {
+		return kr.keys[i].ActiveFrom.Before(kr.keys[j].ActiveFrom)
+	})
+	for _, k := range kr.keys {
+		if k.KeyID == "" {
+			return nil, errors.New("signing key without kid")
+		}
+		if _, dup := kr.signers[k.KeyID]; dup {
+			return nil, fmt.Errorf("duplicate signing key %q", k.KeyID)
+		}
+		if err := checkKeyType(k.Algorithm, k.Key); err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", k.KeyID, err)
+		}
+		s, err := jose.NewSigner(
+			jose.SigningKey{
+				Algorithm: jose.SignatureAlgorithm(k.Algorithm),
+				Key:       jose.JSONWebKey{Key: k.Key, KeyID: k.KeyID},
+			},
+			(&jose.SignerOptions{}).WithType(JWT),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", k.KeyID, err)
+		}
+		kr.signers[k.KeyID] = s
+	}
+	return kr, nil
+}
+
+func (kr *Keyring) State(key SigningKey, now time.Time) string {
+	if !key.RetireAt.IsZero() && !now.Before(key.RetireAt) {
+		return KEY_STATE_RETIRED
+	}
+	if now.Before(key.ActiveFrom) {
+		return KEY_STATE_NEXT
+	}
+	if cur, ok := kr.current(now); ok && cur.KeyID == key.KeyID {
+		return KEY_STATE_CURRENT
+	}
+	return KEY_STATE_RETIRING
+}
+
+func (kr *Keyring) Current(now time.Time) (SigningKey, jose.Signer, error) {
+	key, ok := kr.current(now)
+	if !ok {
+		return SigningKey{}, nil, ErrNoSigningKey
+	}
+	return key, kr.signers[key.KeyID], nil
+}
+
+func (kr *Keyring) PublicKeys(now time.Time) jose.JSONWebKeySet {
+	var set jose.JSONWebKeySet
+	for _, k := range kr.keys {
+		if kr.State(k, now) == KEY_STATE_RETIRED {
+			continue
+		}
+		signer, ok := k.Key.(crypto.Signer)
+		if !ok {
+			continue
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       signer.Public(),
+			KeyID:     k.KeyID,
+			Algorithm: k.Algorithm,
+			Use:       SIG,
+		})
+	}
+	return set
+}
+
+// VerificationKeys returns every non-retired key in the form needed to verify tokens this keyring
+// signed, including HMAC secrets. Unlike PublicKeys it must never be published.
+func (kr *Keyring) VerificationKeys(now time.Time) jose.JSONWebKeySet {
+	set := kr.PublicKeys(now)
+	for _, k := range kr.keys {
+		secret, ok := k.Key.([]byte)
+		if !ok || kr.State(k, now) == KEY_STATE_RETIRED {
+			continue
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       secret,
+			KeyID:     k.KeyID,
+			Algorithm: k.Algorithm,
+			Use:       SIG,
+		})
+	}
+	return set
+}
+
+func (kr *Keyring) Algorithms() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, k := range kr.keys {
+		if !seen[k.Algorithm] {
+			seen[k.Algorithm] = true
+			out = append(out, k.Algorithm)
+		}
+	}
+	return out
+}
+
+func (kr *Keyring) current(now time.Time) (SigningKey, bool) {
+	for i := len(kr.keys) - 1; i >= 0; i-- {
+		k := kr.keys[i]
+		if now.Before(k.ActiveFrom) {
+			continue
+		}
+		if !k.RetireAt.IsZero() && !now.Before(k.RetireAt) {
+			continue
+		}
+		return k, true
+	}
+	return SigningKey{}, false
+}
+
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+func HMACKeyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return "hs-" + hex.EncodeToString(sum[:8])
+}
+
+func checkKeyType(alg string, key any) error {
+	switch jose.SignatureAlgorithm(alg) {
+	case jose.HS256, jose.HS384, jose.HS512:
+		if _, ok := key.([]byte); ok {
+			return nil
+		}
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		if _, ok := key.(*rsa.PrivateKey); ok {
+			return nil
+		}
+	case jose.ES256:
+		return checkCurve(key, elliptic.P256())
+	case jose.ES384:
+		return checkCurve(key, elliptic.P384())
+	case jose.ES512:
+		return checkCurve(key, elliptic.P521())
+	case jose.EdDSA:
+		if _, ok := key.(ed25519.PrivateKey); ok {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return fmt.Errorf("key type %T cannot sign %s", key, alg)
+}
+
+func checkCurve(key any, curve elliptic.Curve) error {
+	ec, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ec.Curve != curve {
+		return fmt.Errorf("key is not an ECDSA %s key", curve.Params().Name)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	Jwt "github.com/go-jose/go-jose/v4/jwt"
+)
+
+func TestKeyring_Rotation(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	keys := []SigningKey{
+		{KeyID: "next", Algorithm: "ES256", Key: newKey(), ActiveFrom: now.Add(24 * time.Hour)},
+		{KeyID: "retired", Algorithm: "ES256", Key: newKey(), ActiveFrom: now.Add(-90 * 24 * time.Hour), RetireAt: now.Add(-time.Hour)},
+		{KeyID: "current", Algorithm: "ES256", Key: newKey(), ActiveFrom: now.Add(-24 * time.Hour)},
+		{KeyID: "retiring", Algorithm: "ES256", Key: newKey(), ActiveFrom: now.Add(-30 * 24 * time.Hour), RetireAt: now.Add(7 * 24 * time.Hour)},
+	}
+	kr, err := NewKeyring(keys)
+	if err != nil {
+		t.Fatalf("NewKeyring error: %v", err)
+	}
+
+	for _, k := range keys {
+		if got := kr.State(k, now); got != k.KeyID {
+			t.Errorf("State(%s) = %s", k.KeyID, got)
+		}
+	}
+
+	cur, _, err := kr.Current(now)
+	if err != nil || cur.KeyID != "current" {
+		t.Fatalf("Current = %q, %v; want current", cur.KeyID, err)
+	}
+	cur, _, err = kr.Current(now.Add(48 * time.Hour))
+	if err != nil || cur.KeyID != "next" {
+		t.Errorf("Current after rollover = %q, %v; want next", cur.KeyID, err)
+	}
+
+	published := map[string]bool{}
+	for _, k := range kr.PublicKeys(now).Keys {
+		if !k.IsPublic() {
+			t.Errorf("PublicKeys exposed private material for %s", k.KeyID)
+		}
+		published[k.KeyID] = true
+	}
+	if len(published) != 3 || !published["next"] || !published["current"] || !published["retiring"] {
+		t.Errorf("PublicKeys published %v; want next, current, retiring", published)
+	}
+}
+
+func TestKeyring_SignedTokenCarriesKid(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr, err := NewKeyring([]SigningKey{{KeyID: "ed-1", Algorithm: "EdDSA", Key: priv}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signer, err := kr.Current(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := Jwt.Signed(signer).Claims(Jwt.Claims{Subject: "s"}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Jwt.ParseSigned(tok, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := parsed.Headers[0].KeyID; kid != "ed-1" {
+		t.Errorf("kid header = %q, want ed-1", kid)
+	}
+}
+
+func TestNewKeyring_RejectsMismatchedKey(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeyring([]SigningKey{{KeyID: "k", Algorithm: "ES256", Key: ec}}); err == nil {
+		t.Error("NewKeyring accepted a P-384 key for ES256")
+	}
+	if _, err := NewKeyring([]SigningKey{{KeyID: "k", Algorithm: "RS256", Key: ec}}); err == nil {
+		t.Error("NewKeyring accepted an EC key for RS256")
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	key, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		t.Fatalf("ParsePrivateKeyPEM error: %v", err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("ParsePrivateKeyPEM returned %T, want *ecdsa.PrivateKey", key)
+	}
+	if _, err := ParsePrivateKeyPEM([]byte("not pem")); err == nil {
+		t.Error("ParsePrivateKeyPEM expected error for garbage input")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,7 +15,6 @@ import (
 	"github.com/go-jose/go-jose/v4"
 	Jwt "github.com/go-jose/go-jose/v4/jwt"
 
-	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
 )
 
@@ -27,82 +27,143 @@ const (
 	CLIENT_SECRET = "client_secret"
 	GRANT_TYPE    = "grant_type"
 	CODE          = "code"
+	LOGIN_HINT    = "login_hint"
+	SUBJECT       = "sub"
+	PHONE_NUMBER  = "phone_number"
 	REALM         = `Basic realm="telco"`
+
+	// TOKEN_USE marks what a broker token is for. ID tokens carry TOKEN_USE_ID; access tokens carry
+	// TOKEN_USE_ACCESS or, if minted before the claim existed, nothing.
+	TOKEN_USE        = "token_use"
+	TOKEN_USE_ACCESS = "access"
+	TOKEN_USE_ID     = "id"
 )
 
 var (
-	privKey *rsa.PrivateKey
-	pubJWK  jose.JSONWebKey
-	signer  jose.Signer
+	keyring *Keyring
+
+	DefaultAlgorithms = []string{
+		string(jose.RS256),
+		string(jose.PS256),
+		string(jose.ES256),
+		string(jose.EdDSA),
+	}
+
+	registeredClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+	asymmetricAlgorithms = []jose.SignatureAlgorithm{
+		jose.RS256, jose.RS384, jose.RS512,
+		jose.PS256, jose.PS384, jose.PS512,
+		jose.ES256, jose.ES384, jose.ES512,
+		jose.EdDSA,
+	}
+
+	hmacAlgorithms = []jose.SignatureAlgorithm{jose.HS256, jose.HS384, jose.HS512}
 )
 
 type Payload struct {
+	ID        string
 	Issuer    string
 	Subject   string
 	Audience  []string
+	IssuedAt  time.Time
 	ExpiresAt time.Time
 	Extra     map[string]any
 }
 
+// Claim returns a string claim by name; "sub" maps to Subject, anything else is looked up in Extra.
+func (p *Payload) Claim(name string) (string, bool) {
+	if name == SUBJECT {
+		return p.Subject, p.Subject != ""
+	}
+	v, ok := p.Extra[name].(string)
+	return v, ok && v != ""
+}
+
+type Expected struct {
+	Issuer     string
+	Audience   []string
+	Leeway     time.Duration
+	Algorithms []string
+	Revoked    RevocationChecker
+	// TokenUse, when set, rejects tokens whose token_use claim differs; a missing claim reads as access.
+	TokenUse string
+}
+
+type KeySource interface {
+	Keys(ctx context.Context) (jose.JSONWebKeySet, error)
+	Refresh(ctx context.Context) (jose.JSONWebKeySet, error)
+}
+
+type StaticKeys struct {
+	Set jose.JSONWebKeySet
+}
+
+func (s StaticKeys) Keys(ctx context.Context) (jose.JSONWebKeySet, error) {
+	return s.Set, nil
+}
+
+func (s StaticKeys) Refresh(ctx context.Context) (jose.JSONWebKeySet, error) {
+	return s.Set, nil
+}
+
 func Init(keyID string, bits int) error {
-	var err error
-	privKey, err = rsa.GenerateKey(rand.Reader, bits)
+	privKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return fmt.Errorf("generate RSA key: %w", err)
 	}
-
-	pubJWK = jose.JSONWebKey{
-		Key:       privKey.Public(),
+	return InitKeyring([]SigningKey{{
 		KeyID:     keyID,
 		Algorithm: string(jose.RS256),
-		Use:       SIG,
-	}
+		Key:       privKey,
+	}})
+}
+
+func InitHS256(secret []byte) error {
+	return InitKeyring([]SigningKey{{
+		KeyID:     HMACKeyID(secret),
+		Algorithm: HS256,
+		Key:       secret,
+	}})
+}
 
-	signer, err = jose.NewSigner(
-		jose.SigningKey{Algorithm: jose.RS256, Key: privKey},
-		(&jose.SignerOptions{}).WithType(JWT),
-	)
+func InitKeyring(keys []SigningKey) error {
+	kr, err := NewKeyring(keys)
 	if err != nil {
-		return fmt.Errorf("create signer: %w", err)
+		return fmt.Errorf("create keyring: %w", err)
 	}
+	keyring = kr
 	return nil
 }
 
-func InitHS256(secret []byte) error {
-	var err error
-	signer, err = jose.NewSigner(
-		jose.SigningKey{Algorithm: jose.HS256, Key: secret},
-		(&jose.SignerOptions{}).
-			WithType(JWT).
-			WithHeader(ALG, HS256),
-	)
-	return err
+func SigningAlgorithms() []string {
+	return keyring.Algorithms()
 }
 
 func JWKsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		utilities.WriteJSONError(w, "method not allowed", r.Method, http.StatusMethodNotAllowed)
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "JWKS requests must use GET", http.StatusMethodNotAllowed)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{pubJWK}}
-	json.NewEncoder(w).Encode(jwks)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(keyring.PublicKeys(time.Now()))
 }
 
 func JWTsHandler(expectedID, expectedSecret, issuer string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			utilities.WriteJSONError(w, "method not allowed", r.Method, http.StatusMethodNotAllowed)
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "token requests must use POST", http.StatusMethodNotAllowed)
 			return
 		}
 
 		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
-			utilities.WriteJSONError(w, "unsupported media type", ct, http.StatusUnsupportedMediaType)
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "token requests must be form encoded", http.StatusUnsupportedMediaType)
 			return
 		}
 
 		if err := r.ParseForm(); err != nil {
-			utilities.WriteJSONError(w, "invalid form", err.Error(), http.StatusBadRequest)
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "request body is not a valid form", http.StatusBadRequest)
 			return
 		}
 
@@ -113,35 +174,70 @@ func JWTsHandler(expectedID, expectedSecret, issuer string) http.HandlerFunc {
 		}
 		if id != expectedID || secret != expectedSecret {
 			w.Header().Set("WWW-Authenticate", REALM)
-			utilities.WriteJSONError(w, "unauthorized", "", http.StatusUnauthorized)
+			utilities.WriteJSONError(w, utilities.InvalidClient, "client authentication failed", http.StatusUnauthorized)
 			return
 		}
 
 		grantType := r.PostFormValue(GRANT_TYPE)
 		code := r.PostFormValue(CODE)
 		if grantType == "" || code == "" {
-			utilities.WriteJSONError(w, "grant_type and code required", fmt.Sprintf("grantType %s code %s", grantType, code), http.StatusBadRequest)
+			utilities.WriteJSONError(w, utilities.InvalidRequest, "grant_type and code are required", http.StatusBadRequest)
 			return
 		}
 
-		token, err := Sign(issuer, code, []string{id}, time.Hour)
+		issued, ok := takeMockCode(code)
+		if !ok {
+			utilities.WriteJSONError(w, utilities.InvalidGrant, "code is invalid or expired", http.StatusBadRequest)
+			return
+		}
+		if issued.redirectURI != r.PostFormValue(REDIRECT_URI) || !checkMockPKCE(r.PostFormValue(CODE_VERIFIER), issued.challenge) {
+			utilities.WriteJSONError(w, utilities.InvalidGrant, "redirect_uri or code_verifier does not match", http.StatusBadRequest)
+			return
+		}
+		subject, phone := issued.phone, issued.phone
+
+		var extra map[string]any
+		if phone != "" {
+			extra = map[string]any{PHONE_NUMBER: phone}
+		}
+		token, err := Sign(issuer, subject, []string{id}, time.Hour, extra)
 		if err != nil {
 			log.Printf("error signing token: %v", err)
-			utilities.WriteJSONError(w, "internal error", err.Error(), http.StatusInternalServerError)
+			utilities.WriteJSONError(w, utilities.ServerError, "", http.StatusInternalServerError)
 			return
 		}
 
 		resp := map[string]any{
-			"access_token": token,
-			"token_type":   "Bearer",
-			"expires_in":   3600,
+			ACCESS_TOKEN: token,
+			"token_type": "Bearer",
+			"expires_in": 3600,
+		}
+		if HasScope(r.PostFormValue(SCOPE), SCOPE_OPENID) {
+			atHash, err := AtHash(token)
+			if err != nil {
+				log.Printf("error hashing token: %v", err)
+				utilities.WriteJSONError(w, utilities.ServerError, "", http.StatusInternalServerError)
+				return
+			}
+			idClaims := map[string]any{AT_HASH: atHash, "auth_time": time.Now().Unix()}
+			if phone != "" {
+				idClaims[PHONE_NUMBER] = phone
+				idClaims[PHONE_VERIFIED] = true
+			}
+			idToken, err := Sign(issuer, subject, []string{id}, time.Hour, idClaims)
+			if err != nil {
+				log.Printf("error signing id token: %v", err)
+				utilities.WriteJSONError(w, utilities.ServerError, "", http.StatusInternalServerError)
+				return
+			}
+			resp[ID_TOKEN] = idToken
 		}
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		json.NewEncoder(w).Encode(resp)
 	}
 }
 
-func Sign(issuer, subject string, audience []string, expiresIn time.Duration) (string, error) {
+func Sign(issuer, subject string, audience []string, expiresIn time.Duration, extra map[string]any) (string, error) {
 	now := time.Now()
 	claims := Jwt.Claims{
 		Issuer:   issuer,
@@ -150,54 +246,178 @@ func Sign(issuer, subject string, audience []string, expiresIn time.Duration) (s
 		IssuedAt: Jwt.NewNumericDate(now),
 		Expiry:   Jwt.NewNumericDate(now.Add(expiresIn)),
 	}
-	return Jwt.Signed(signer).Claims(claims).Serialize()
+	_, signer, err := keyring.Current(now)
+	if err != nil {
+		return "", err
+	}
+	builder := Jwt.Signed(signer).Claims(claims)
+	if len(extra) > 0 {
+		builder = builder.Claims(extra)
+	}
+	return builder.Serialize()
+}
+
+func Validate(ctx context.Context, tokenStr string, keys KeySource, exp Expected) (*Payload, error) {
+	return validate(ctx, tokenStr, keys, exp, asymmetricAlgorithms)
 }
 
-func Validate(ctx context.Context, tokenStr string, tc *clients.TelcoClient, jwksURL string) (*Payload, error) {
-	set, err := tc.GetJWKs(ctx, jwksURL)
+// ValidateIssued checks a token minted by this process against its own keyring, HMAC keys included.
+// An empty exp.Algorithms accepts any algorithm the keyring signs with.
+func ValidateIssued(ctx context.Context, tokenStr string, exp Expected) (*Payload, error) {
+	now := time.Now()
+	if len(exp.Algorithms) == 0 {
+		exp.Algorithms = keyring.Algorithms()
+	}
+	keys := StaticKeys{Set: keyring.VerificationKeys(now)}
+	return validate(ctx, tokenStr, keys, exp, append(append([]jose.SignatureAlgorithm(nil), asymmetricAlgorithms...), hmacAlgorithms...))
+}
+
+func validate(ctx context.Context, tokenStr string, keys KeySource, exp Expected, algs []jose.SignatureAlgorithm) (*Payload, error) {
+	parsed, err := Jwt.ParseSigned(tokenStr, algs)
 	if err != nil {
-		return nil, err
+		return nil, invalid(CHECK_HEADER, fmt.Errorf("%w: %v", ErrMalformed, err))
+	}
+	header := parsed.Headers[0]
+	if !allowed(header.Algorithm, exp.Algorithms) {
+		return nil, invalid(CHECK_ALG, fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, header.Algorithm))
 	}
 
-	parsed, err := Jwt.ParseSigned(tokenStr, []jose.SignatureAlgorithm{jose.RS256})
+	set, err := keys.Keys(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("parse token: %w", err)
+		return nil, err
+	}
+	candidates := selectKeys(set, header.KeyID, header.Algorithm)
+	if len(candidates) == 0 && header.KeyID != "" {
+		fresh, err := keys.Refresh(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("refresh jwks on kid miss: %w", err)
+		}
+		candidates = selectKeys(fresh, header.KeyID, header.Algorithm)
+	}
+	if len(candidates) == 0 {
+		return nil, invalid(CHECK_SIGNATURE, fmt.Errorf("%w: kid %q", ErrUnknownKey, header.KeyID))
 	}
 
 	var claims Jwt.Claims
-	for _, key := range set.Keys {
-		if err := parsed.Claims(key.Key, &claims); err == nil {
-			return &Payload{
-				Issuer:    claims.Issuer,
-				Subject:   claims.Subject,
-				Audience:  claims.Audience,
-				ExpiresAt: claims.Expiry.Time(),
-			}, nil
+	var extra map[string]any
+	for _, key := range candidates {
+		if err := parsed.Claims(key.Key, &claims, &extra); err == nil {
+			p, err := checkClaims(claims, exp, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range registeredClaims {
+				delete(extra, name)
+			}
+			p.Extra = extra
+			if use := tokenUse(p); exp.TokenUse != "" && use != exp.TokenUse {
+				return nil, invalid(CHECK_TOKEN_USE, fmt.Errorf("%w: %s", ErrWrongTokenUse, use))
+			}
+			if exp.Revoked != nil && exp.Revoked.IsRevoked(p) {
+				return nil, invalid(CHECK_REVOKED, ErrRevoked)
+			}
+			return p, nil
 		}
 	}
+	return nil, invalid(CHECK_SIGNATURE, ErrInvalidSignature)
+}
 
-	fresh, err := tc.FetchJWKs(ctx, jwksURL)
+func tokenUse(p *Payload) string {
+	if use, ok := p.Claim(TOKEN_USE); ok {
+		return use
+	}
+	return TOKEN_USE_ACCESS
+}
+
+func PeekIssuer(tokenStr string) (string, error) {
+	parsed, err := Jwt.ParseSigned(tokenStr, asymmetricAlgorithms)
 	if err != nil {
-		return nil, fmt.Errorf("refresh jwks on kid miss: %w", err)
+		return "", fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var claims Jwt.Claims
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrMalformed, err)
 	}
+	return claims.Issuer, nil
+}
 
-	for _, key := range fresh.Keys {
-		if err := parsed.Claims(key.Key, &claims); err == nil {
-			return &Payload{
-				Issuer:    claims.Issuer,
-				Subject:   claims.Subject,
-				Audience:  claims.Audience,
-				ExpiresAt: claims.Expiry.Time(),
-			}, nil
+func selectKeys(set jose.JSONWebKeySet, kid, alg string) []jose.JSONWebKey {
+	var out []jose.JSONWebKey
+	for _, key := range set.Keys {
+		if kid != "" && key.KeyID != kid {
+			continue
+		}
+		if key.Use != "" && key.Use != SIG {
+			continue
 		}
+		if key.Algorithm != "" && key.Algorithm != alg {
+			continue
+		}
+		out = append(out, key)
 	}
+	return out
+}
 
-	return nil, fmt.Errorf("invalid token signature after refresh")
+func allowed(alg string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		allowlist = DefaultAlgorithms
+	}
+	for _, a := range allowlist {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func checkClaims(claims Jwt.Claims, exp Expected, now time.Time) (*Payload, error) {
+	if claims.Expiry == nil {
+		return nil, invalid(CHECK_EXP, ErrMissingExpiry)
+	}
+	if now.Add(-exp.Leeway).After(claims.Expiry.Time()) {
+		return nil, invalid(CHECK_EXP, ErrExpired)
+	}
+	if claims.NotBefore != nil && now.Add(exp.Leeway).Before(claims.NotBefore.Time()) {
+		return nil, invalid(CHECK_NBF, ErrNotValidYet)
+	}
+	if exp.Issuer != "" && claims.Issuer != exp.Issuer {
+		return nil, invalid(CHECK_ISS, fmt.Errorf("%w: %q", ErrInvalidIssuer, claims.Issuer))
+	}
+	if len(exp.Audience) > 0 && !containsAny(claims.Audience, exp.Audience) {
+		return nil, invalid(CHECK_AUD, ErrInvalidAudience)
+	}
+
+	return &Payload{
+		ID:        claims.ID,
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		IssuedAt:  claims.IssuedAt.Time(),
+		ExpiresAt: claims.Expiry.Time(),
+	}, nil
+}
+
+func containsAny(aud Jwt.Audience, want []string) bool {
+	for _, w := range want {
+		if aud.Contains(w) {
+			return true
+		}
+	}
+	return false
 }
 
+// NewID returns a random token identifier suitable for "jti".
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Mint signs p with the current key. Extra claims are written at the top level; registered claims win.
 func Mint(p Payload) (string, error) {
 	now := time.Now()
 	std := Jwt.Claims{
+		ID:       p.ID,
 		Issuer:   p.Issuer,
 		Subject:  p.Subject,
 		Audience: p.Audience,
@@ -205,14 +425,15 @@ func Mint(p Payload) (string, error) {
 		IssuedAt: Jwt.NewNumericDate(now),
 	}
 
-	raw := struct {
-		Jwt.Claims
-		Extra map[string]any `json:"extra,omitempty"`
-	}{
-		Claims: std,
-		Extra:  p.Extra,
+	_, signer, err := keyring.Current(now)
+	if err != nil {
+		return "", fmt.Errorf("mint token: %w", err)
+	}
+	builder := Jwt.Signed(signer)
+	if len(p.Extra) > 0 {
+		builder = builder.Claims(p.Extra)
 	}
-	tok, err := Jwt.Signed(signer).Claims(raw).Serialize()
+	tok, err := builder.Claims(std).Serialize()
 	if err != nil {
 		return "", fmt.Errorf("mint token: %w", err)
 	}
@@ -0,0 +1,215 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	Jwt "github.com/go-jose/go-jose/v4/jwt"
+)
+
+func TestCheckClaims(t *testing.T) {
+	now := time.Now()
+	exp := Expected{
+		Issuer:   "http://telco",
+		Audience: []string{"broker"},
+		Leeway:   30 * time.Second,
+	}
+	valid := Jwt.Claims{
+		Issuer:   "http://telco",
+		Subject:  "972541234567",
+		Audience: Jwt.Audience{"broker"},
+		Expiry:   Jwt.NewNumericDate(now.Add(time.Minute)),
+	}
+
+	cases := []struct {
+		name      string
+		mutate    func(c *Jwt.Claims)
+		wantCheck string
+		wantErr   error
+	}{
+		{"valid", func(c *Jwt.Claims) {}, "", nil},
+		{"expired within leeway", func(c *Jwt.Claims) { c.Expiry = Jwt.NewNumericDate(now.Add(-10 * time.Second)) }, "", nil},
+		{"expired", func(c *Jwt.Claims) { c.Expiry = Jwt.NewNumericDate(now.Add(-time.Minute)) }, CHECK_EXP, ErrExpired},
+		{"no expiry", func(c *Jwt.Claims) { c.Expiry = nil }, CHECK_EXP, ErrMissingExpiry},
+		{"not yet valid", func(c *Jwt.Claims) { c.NotBefore = Jwt.NewNumericDate(now.Add(time.Minute)) }, CHECK_NBF, ErrNotValidYet},
+		{"foreign issuer", func(c *Jwt.Claims) { c.Issuer = "http://other" }, CHECK_ISS, ErrInvalidIssuer},
+		{"wrong audience", func(c *Jwt.Claims) { c.Audience = Jwt.Audience{"someone-else"} }, CHECK_AUD, ErrInvalidAudience},
+	}
+
+	for _, c := range cases {
+		claims := valid
+		c.mutate(&claims)
+		_, err := checkClaims(claims, exp, now)
+		if c.wantErr == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: error = %v, want *ValidationError", c.name, err)
+			continue
+		}
+		if verr.Check != c.wantCheck || !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: got check %q err %v, want check %q err %v", c.name, verr.Check, err, c.wantCheck, c.wantErr)
+		}
+	}
+}
+
+type fakeKeys struct {
+	cached    jose.JSONWebKeySet
+	fresh     jose.JSONWebKeySet
+	refreshes int
+}
+
+func (f *fakeKeys) Keys(ctx context.Context) (jose.JSONWebKeySet, error) {
+	return f.cached, nil
+}
+
+func (f *fakeKeys) Refresh(ctx context.Context) (jose.JSONWebKeySet, error) {
+	f.refreshes++
+	f.cached = f.fresh
+	return f.fresh, nil
+}
+
+func signWith(t *testing.T, alg jose.SignatureAlgorithm, key any, kid string, claims Jwt.Claims) string {
+	t.Helper()
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: alg, Key: jose.JSONWebKey{Key: key, KeyID: kid}},
+		(&jose.SignerOptions{}).WithType(JWT),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := Jwt.Signed(sig).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestValidate_KeySelection(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := Jwt.Claims{
+		Issuer:   "http://telco",
+		Subject:  "972541234567",
+		Audience: Jwt.Audience{"broker"},
+		Expiry:   Jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	exp := Expected{Issuer: "http://telco", Audience: []string{"broker"}}
+	ecJWK := jose.JSONWebKey{Key: ecKey.Public(), KeyID: "ec-1", Algorithm: string(jose.ES256), Use: SIG}
+	edJWK := jose.JSONWebKey{Key: edPub, KeyID: "ed-1", Algorithm: string(jose.EdDSA), Use: SIG}
+
+	t.Run("selects key by kid", func(t *testing.T) {
+		keys := &fakeKeys{cached: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{edJWK, ecJWK}}}
+		tok := signWith(t, jose.ES256, ecKey, "ec-1", claims)
+		if _, err := Validate(context.Background(), tok, keys, exp); err != nil {
+			t.Fatalf("Validate error: %v", err)
+		}
+		if keys.refreshes != 0 {
+			t.Errorf("refreshes = %d, want 0", keys.refreshes)
+		}
+	})
+
+	t.Run("refreshes on unknown kid", func(t *testing.T) {
+		keys := &fakeKeys{
+			cached: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{ecJWK}},
+			fresh:  jose.JSONWebKeySet{Keys: []jose.JSONWebKey{ecJWK, edJWK}},
+		}
+		tok := signWith(t, jose.EdDSA, edKey, "ed-1", claims)
+		if _, err := Validate(context.Background(), tok, keys, exp); err != nil {
+			t.Fatalf("Validate error: %v", err)
+		}
+		if keys.refreshes != 1 {
+			t.Errorf("refreshes = %d, want 1", keys.refreshes)
+		}
+	})
+
+	t.Run("bad signature does not refresh", func(t *testing.T) {
+		keys := &fakeKeys{cached: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{ecJWK}}}
+		tok := signWith(t, jose.ES256, otherKey, "ec-1", claims)
+		_, err := Validate(context.Background(), tok, keys, exp)
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Validate error = %v, want ErrInvalidSignature", err)
+		}
+		if keys.refreshes != 0 {
+			t.Errorf("refreshes = %d, want 0", keys.refreshes)
+		}
+	})
+
+	t.Run("jwk alg must match header", func(t *testing.T) {
+		mislabeled := ecJWK
+		mislabeled.Algorithm = string(jose.ES384)
+		keys := &fakeKeys{cached: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{mislabeled}}}
+		tok := signWith(t, jose.ES256, ecKey, "ec-1", claims)
+		if _, err := Validate(context.Background(), tok, keys, exp); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Validate error = %v, want ErrUnknownKey", err)
+		}
+	})
+
+	t.Run("algorithm outside allowlist", func(t *testing.T) {
+		keys := &fakeKeys{cached: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{ecJWK}}}
+		tok := signWith(t, jose.ES256, ecKey, "ec-1", claims)
+		rsOnly := exp
+		rsOnly.Algorithms = []string{string(jose.RS256)}
+		if _, err := Validate(context.Background(), tok, keys, rsOnly); !errors.Is(err, ErrAlgorithmNotAllowed) {
+			t.Errorf("Validate error = %v, want ErrAlgorithmNotAllowed", err)
+		}
+	})
+
+	t.Run("symmetric algorithm rejected", func(t *testing.T) {
+		keys := &fakeKeys{cached: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{ecJWK}}}
+		tok := signWith(t, jose.HS256, []byte("0123456789abcdef0123456789abcdef"), "ec-1", claims)
+		if _, err := Validate(context.Background(), tok, keys, exp); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Validate error = %v, want ErrMalformed", err)
+		}
+	})
+}
+
+func TestValidate_ExposesPrivateClaims(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := InitKeyring([]SigningKey{{KeyID: "k1", Algorithm: string(jose.ES256), Key: priv}}); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+	tok, err := Sign("https://telco", "subscriber-1", []string{"broker"}, time.Minute, map[string]any{PHONE_NUMBER: "+972541234567"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	keys := StaticKeys{Set: keyring.PublicKeys(time.Now())}
+	p, err := Validate(context.Background(), tok, keys, Expected{Issuer: "https://telco", Audience: []string{"broker"}})
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got, ok := p.Claim(PHONE_NUMBER); !ok || got != "+972541234567" {
+		t.Errorf("Claim(phone_number) = %q, %v", got, ok)
+	}
+	if got, ok := p.Claim(SUBJECT); !ok || got != "subscriber-1" {
+		t.Errorf("Claim(sub) = %q, %v", got, ok)
+	}
+	if _, ok := p.Extra["iss"]; ok {
+		t.Error("registered claim iss leaked into Extra")
+	}
+}
@@ -0,0 +1,50 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const (
+	X5T_S256   = "x5t#S256"
+	CHECK_MTLS = "mtls"
+)
+
+var ErrCertificateBinding = errors.New("token is not bound to the client certificate")
+
+// CertificateThumbprint is the RFC 8705 "x5t#S256" value: the base64url SHA-256 of the DER certificate.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ConfirmationX5T returns the certificate thumbprint a token is bound to, if any.
+func ConfirmationX5T(p *Payload) (string, bool) {
+	return confirmation(p, X5T_S256)
+}
+
+// VerifyCertificateBound is for resource servers: it checks that a certificate-bound access token is
+// presented over a connection authenticated with the same client certificate.
+func VerifyCertificateBound(claims *Payload, cert *x509.Certificate) error {
+	x5t, ok := ConfirmationX5T(claims)
+	if !ok {
+		return invalid(CHECK_MTLS, fmt.Errorf("%w: token has no cnf.x5t#S256", ErrCertificateBinding))
+	}
+	if cert == nil || subtle.ConstantTimeCompare([]byte(CertificateThumbprint(cert)), []byte(x5t)) != 1 {
+		return invalid(CHECK_MTLS, ErrCertificateBinding)
+	}
+	return nil
+}
+
+func confirmation(p *Payload, member string) (string, bool) {
+	cnf, ok := p.Extra[CONFIRMATION].(map[string]any)
+	if !ok {
+		return "", false
+	}
+	v, ok := cnf[member].(string)
+	return v, ok && v != ""
+}
@@ -0,0 +1,140 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+const revocationSweepInterval = time.Minute
+
+// RevocationChecker reports whether an otherwise valid token has been revoked.
+type RevocationChecker interface {
+	IsRevoked(p *Payload) bool
+}
+
+// revocation covers tokens issued before at. Token iat has whole-second precision, so at is truncated
+// to the second: a token minted in the same second as the revocation is kept.
+type revocation struct {
+	at    time.Time
+	until time.Time
+}
+
+// RevocationList remembers revoked token IDs until they expire, and subjects or phone numbers whose
+// tokens issued up to the moment of revocation must no longer be accepted. Tokens do not always carry
+// the phone_number claim, so it also remembers which subjects each phone's tokens were issued to.
+type RevocationList struct {
+	mu        sync.Mutex
+	ids       map[string]time.Time
+	subjects  map[string]revocation
+	phones    map[string]revocation
+	issued    map[string]map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		ids:      make(map[string]time.Time),
+		subjects: make(map[string]revocation),
+		phones:   make(map[string]revocation),
+		issued:   make(map[string]map[string]time.Time),
+	}
+}
+
+// BindPhone records that a token for subject, valid until expiresAt, was issued for phone, so that
+// RevokePhone covers it even when the phone_number claim was filtered out of the token.
+func (l *RevocationList) BindPhone(phone, subject string, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(time.Now())
+	subjects := l.issued[phone]
+	if subjects == nil {
+		subjects = make(map[string]time.Time)
+		l.issued[phone] = subjects
+	}
+	if expiresAt.After(subjects[subject]) {
+		subjects[subject] = expiresAt
+	}
+}
+
+// RevokeID revokes a single token; the entry is dropped once expiresAt has passed.
+func (l *RevocationList) RevokeID(jti string, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(time.Now())
+	l.ids[jti] = expiresAt
+}
+
+// RevokeSubject revokes every token for subject issued until now. until should be at least the longest
+// lifetime of a token issued before now.
+func (l *RevocationList) RevokeSubject(subject string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	l.subjects[subject] = revocation{at: now.Truncate(time.Second), until: until}
+}
+
+// RevokePhone is RevokeSubject keyed on the phone_number claim. Subjects bound to phone with BindPhone
+// are revoked as well.
+func (l *RevocationList) RevokePhone(phone string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	r := revocation{at: now.Truncate(time.Second), until: until}
+	l.phones[phone] = r
+	for subject := range l.issued[phone] {
+		l.subjects[subject] = r
+	}
+}
+
+func (l *RevocationList) IsRevoked(p *Payload) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p.ID != "" {
+		if _, ok := l.ids[p.ID]; ok {
+			return true
+		}
+	}
+	if r, ok := l.subjects[p.Subject]; ok && p.IssuedAt.Before(r.at) {
+		return true
+	}
+	if phone, ok := p.Claim(PHONE_NUMBER); ok {
+		if r, ok := l.phones[phone]; ok && p.IssuedAt.Before(r.at) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *RevocationList) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	for id, exp := range l.ids {
+		if now.After(exp) {
+			delete(l.ids, id)
+		}
+	}
+	for sub, r := range l.subjects {
+		if now.After(r.until) {
+			delete(l.subjects, sub)
+		}
+	}
+	for phone, r := range l.phones {
+		if now.After(r.until) {
+			delete(l.phones, phone)
+		}
+	}
+	for phone, subjects := range l.issued {
+		for subject, exp := range subjects {
+			if now.After(exp) {
+				delete(subjects, subject)
+			}
+		}
+		if len(subjects) == 0 {
+			delete(l.issued, phone)
+		}
+	}
+	l.nextSweep = now.Add(revocationSweepInterval)
+}
@@ -0,0 +1,106 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateIssued_Revocation(t *testing.T) {
+	if err := InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	mint := func(id, sub string) string {
+		tok, err := Mint(Payload{
+			ID:        id,
+			Issuer:    "sim-broker",
+			Subject:   sub,
+			Audience:  []string{"demo-app"},
+			ExpiresAt: time.Now().Add(time.Minute),
+			Extra:     map[string]any{PHONE_NUMBER: "+972541234567"},
+		})
+		if err != nil {
+			t.Fatalf("Mint: %v", err)
+		}
+		return tok
+	}
+
+	revoked := NewRevocationList()
+	exp := Expected{Issuer: "sim-broker", Revoked: revoked}
+	earlier := time.Now().Add(-time.Second)
+
+	a := mint("jti-a", "sub-a")
+	if _, err := ValidateIssued(context.Background(), a, exp); err != nil {
+		t.Fatalf("ValidateIssued: %v", err)
+	}
+	revoked.RevokeID("jti-a", time.Now().Add(time.Minute))
+	if _, err := ValidateIssued(context.Background(), a, exp); !errors.Is(err, ErrRevoked) {
+		t.Errorf("revoked jti error = %v, want ErrRevoked", err)
+	}
+
+	revoked.RevokePhone("+972541234567", time.Now().Add(time.Minute))
+	if _, err := ValidateIssued(context.Background(), mint("jti-b", "sub-b"), exp); err != nil {
+		t.Errorf("token minted right after the phone revocation error = %v, want it accepted", err)
+	}
+	revoked.RevokeSubject("sub-b", time.Now().Add(time.Minute))
+	if _, err := ValidateIssued(context.Background(), mint("jti-c", "sub-b"), exp); err != nil {
+		t.Errorf("token minted right after the subject revocation error = %v, want it accepted", err)
+	}
+	if !revoked.IsRevoked(&Payload{Subject: "sub-b", IssuedAt: earlier}) {
+		t.Error("token issued before subject revocation is not revoked")
+	}
+	if !revoked.IsRevoked(&Payload{Subject: "sub-c", IssuedAt: earlier, Extra: map[string]any{PHONE_NUMBER: "+972541234567"}}) {
+		t.Error("token issued before phone revocation is not revoked")
+	}
+	if revoked.IsRevoked(&Payload{Subject: "sub-c", IssuedAt: time.Now().Add(time.Hour), Extra: map[string]any{PHONE_NUMBER: "+972541234567"}}) {
+		t.Error("token issued after phone revocation is revoked")
+	}
+}
+
+func TestRevocationList_BoundPhone(t *testing.T) {
+	revoked := NewRevocationList()
+	revoked.BindPhone("+972541234567", "sub-a", time.Now().Add(time.Minute))
+	issued := &Payload{Subject: "sub-a", IssuedAt: time.Now().Add(-time.Second)}
+	if revoked.IsRevoked(issued) {
+		t.Fatal("token revoked before any revocation")
+	}
+	revoked.RevokePhone("+972541234567", time.Now().Add(time.Minute))
+	if !revoked.IsRevoked(issued) {
+		t.Error("token without phone_number claim survived revocation of its bound phone")
+	}
+	if revoked.IsRevoked(&Payload{Subject: "sub-b", IssuedAt: time.Now().Add(-time.Second)}) {
+		t.Error("token for an unrelated subject is revoked")
+	}
+}
+
+func TestValidateIssued_TokenUse(t *testing.T) {
+	if err := InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	mint := func(extra map[string]any) string {
+		tok, err := Mint(Payload{
+			ID:        NewID(),
+			Issuer:    "sim-broker",
+			Subject:   "sub-a",
+			Audience:  []string{"demo-app"},
+			ExpiresAt: time.Now().Add(time.Minute),
+			Extra:     extra,
+		})
+		if err != nil {
+			t.Fatalf("Mint: %v", err)
+		}
+		return tok
+	}
+
+	exp := Expected{Issuer: "sim-broker", TokenUse: TOKEN_USE_ACCESS}
+	if _, err := ValidateIssued(context.Background(), mint(map[string]any{TOKEN_USE: TOKEN_USE_ACCESS}), exp); err != nil {
+		t.Errorf("access token error = %v", err)
+	}
+	if _, err := ValidateIssued(context.Background(), mint(nil), exp); err != nil {
+		t.Errorf("token without token_use error = %v", err)
+	}
+	if _, err := ValidateIssued(context.Background(), mint(map[string]any{TOKEN_USE: TOKEN_USE_ID}), exp); !errors.Is(err, ErrWrongTokenUse) {
+		t.Errorf("ID token error = %v, want ErrWrongTokenUse", err)
+	}
+}
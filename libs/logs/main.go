@@ -20,6 +20,20 @@ func Fatal(logger *slog.Logger, msg string, attrs ...any) {
 	os.Exit(1)
 }
 
+const SecurityEventKey = "security_event"
+
+// SecurityEvent records an event that may indicate abuse so it can be filtered and alerted on.
+func SecurityEvent(logger *slog.Logger, event string, attrs ...any) {
+	logger.Warn("security event", append([]any{SecurityEventKey, event}, attrs...)...)
+}
+
+// Annotate adds attrs to the access log line LoggingMiddleware writes for r.
+func Annotate(r *http.Request, attrs ...slog.Attr) {
+	for _, attr := range attrs {
+		sloghttp.AddCustomAttributes(r, attr)
+	}
+}
+
 func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	options := sloghttp.Config{
 		WithUserAgent:      false,
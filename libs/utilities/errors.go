@@ -0,0 +1,74 @@
+package utilities
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorReporter is the single place handlers turn an error into a response. The full error, including
+// any upstream detail, goes to the log under the request ID; the client only sees the OAuth code, the
+// error's safe description and the request ID to quote when reporting a problem. Debug adds the full
+// error to the response and must only be enabled in development.
+type ErrorReporter struct {
+	logger *slog.Logger
+	debug  bool
+}
+
+func NewErrorReporter(logger *slog.Logger, debug bool) *ErrorReporter {
+	return &ErrorReporter{logger: logger, debug: debug}
+}
+
+// Write reports err for request r. Errors that are not an *OAuthError become a 500 server_error.
+func (e *ErrorReporter) Write(w http.ResponseWriter, r *http.Request, err error) {
+	oe := asOAuthError(err)
+	id := RequestID(r.Context())
+
+	level := slog.LevelInfo
+	if oe.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	e.logger.Log(r.Context(), level, "request failed",
+		"request_id", id,
+		"path", r.URL.Path,
+		"error_code", oe.Code,
+		"status", oe.Status,
+		"error", err,
+	)
+
+	resp := ErrorResponse{Error: oe.Code, ErrorDescription: oe.Description, ErrorID: id}
+	if e.debug {
+		resp.ErrorDebug = err.Error()
+	}
+	if id != "" {
+		w.Header().Set(REQUEST_ID_HEADER_KEY, id)
+	}
+	writeOAuthError(w, oe, resp)
+}
+
+// WriteOAuthError writes err as an RFC 6749 error response without logging it. Errors that are not an
+// *OAuthError become a 500 server_error without their message.
+func WriteOAuthError(w http.ResponseWriter, err error) {
+	oe := asOAuthError(err)
+	writeOAuthError(w, oe, ErrorResponse{Error: oe.Code, ErrorDescription: oe.Description})
+}
+
+func asOAuthError(err error) *OAuthError {
+	var oe *OAuthError
+	if !errors.As(err, &oe) {
+		oe = NewOAuthError(ServerError, "internal error", http.StatusInternalServerError)
+	}
+	return oe
+}
+
+func writeOAuthError(w http.ResponseWriter, oe *OAuthError, resp ErrorResponse) {
+	if oe.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((oe.RetryAfter+time.Second-1)/time.Second)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(oe.Status)
+	json.NewEncoder(w).Encode(resp)
+}
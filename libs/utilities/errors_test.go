@@ -0,0 +1,43 @@
+package utilities
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorReporter(t *testing.T) {
+	cause := errors.New(`telco error 401: {"client_secret":"hunter2"}`)
+	err := fmt.Errorf("exchange: %w", NewOAuthError(ServerError, "operator could not be reached", http.StatusBadGateway).Wrap(cause))
+
+	for _, debug := range []bool{false, true} {
+		var logs bytes.Buffer
+		rep := NewErrorReporter(slog.New(slog.NewJSONHandler(&logs, nil)), debug)
+		r := httptest.NewRequest(http.MethodPost, "/token", nil)
+		r = r.WithContext(context.WithValue(r.Context(), CtxRequestID{}, "req-42"))
+		rec := httptest.NewRecorder()
+		rep.Write(rec, r, err)
+
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("debug=%v: status = %d, want 502", debug, rec.Code)
+		}
+		var body ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Error != ServerError || body.ErrorDescription != "operator could not be reached" || body.ErrorID != "req-42" {
+			t.Errorf("debug=%v: body = %+v", debug, body)
+		}
+		if leaked := strings.Contains(body.ErrorDebug, "hunter2"); leaked != debug {
+			t.Errorf("debug=%v: error_debug = %q", debug, body.ErrorDebug)
+		}
+		if !strings.Contains(logs.String(), "hunter2") || !strings.Contains(logs.String(), "req-42") {
+			t.Errorf("debug=%v: log does not hold the full error and request ID: %s", debug, logs.String())
+		}
+	}
+}
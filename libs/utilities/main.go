@@ -17,6 +17,8 @@ type CtxRequestID struct{}
 type ErrorResponse struct {
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description,omitempty"`
+	ErrorID          string `json:"error_id,omitempty"`
+	ErrorDebug       string `json:"error_debug,omitempty"`
 }
 
 type roundTripperFunc func(*http.Request) (*http.Response, error)
@@ -27,6 +29,12 @@ func WriteJSONError(w http.ResponseWriter, msg, desc string, status int) {
 	json.NewEncoder(w).Encode(ErrorResponse{Error: msg, ErrorDescription: desc})
 }
 
+// RequestID returns the request ID set by RequestIDMiddleware, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(CtxRequestID{}).(string)
+	return id
+}
+
 func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := r.Header.Get(REQUEST_ID_HEADER_KEY)
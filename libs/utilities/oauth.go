@@ -0,0 +1,87 @@
+package utilities
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// OAuth 2.0 error codes (RFC 6749 §5.2 and the extensions the broker serves).
+const (
+	InvalidRequest          = "invalid_request"
+	InvalidClient           = "invalid_client"
+	InvalidGrant            = "invalid_grant"
+	InvalidScope            = "invalid_scope"
+	InvalidTarget           = "invalid_target"
+	InvalidToken            = "invalid_token"
+	InvalidDPoPProof        = "invalid_dpop_proof"
+	UnauthorizedClient      = "unauthorized_client"
+	UnsupportedGrantType    = "unsupported_grant_type"
+	ServerError             = "server_error"
+	TemporarilyUnavailable  = "temporarily_unavailable"
+	UnsupportedTokenType    = "unsupported_token_type"
+	InsufficientScope       = "insufficient_scope"
+	AccessDenied            = "access_denied"
+	UnsupportedResponseType = "unsupported_response_type"
+)
+
+// OAuthError is an error that knows how it is reported to an OAuth client. Err keeps the underlying
+// cause for logs and is never written to the response.
+type OAuthError struct {
+	Code        string
+	Description string
+	Status      int
+	RetryAfter  time.Duration
+	Err         error
+}
+
+func NewOAuthError(code, description string, status int) *OAuthError {
+	return &OAuthError{Code: code, Description: description, Status: status}
+}
+
+func (e *OAuthError) Error() string {
+	msg := e.Code
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns a copy of e carrying err as its cause.
+func (e *OAuthError) Wrap(err error) *OAuthError {
+	out := *e
+	out.Err = err
+	return &out
+}
+
+// UpstreamError maps an error response from an upstream OAuth server to the error the broker reports.
+// Errors caused by what the client sent (a bad code or verifier) pass through as 400s; errors about the
+// broker's own credentials or an unexpected reply are the upstream's fault and become 502s.
+func UpstreamError(status int, body []byte) *OAuthError {
+	var resp ErrorResponse
+	_ = json.Unmarshal(body, &resp)
+	cause := fmt.Errorf("upstream status %d: %s", status, body)
+
+	switch resp.Error {
+	case InvalidGrant:
+		return &OAuthError{Code: InvalidGrant, Description: "authorization code was rejected by the operator", Status: http.StatusBadRequest, Err: cause}
+	case InvalidRequest:
+		return &OAuthError{Code: InvalidRequest, Description: "request was rejected by the operator", Status: http.StatusBadRequest, Err: cause}
+	case InvalidScope:
+		return &OAuthError{Code: InvalidScope, Description: "requested scope was rejected by the operator", Status: http.StatusBadRequest, Err: cause}
+	case TemporarilyUnavailable:
+		return &OAuthError{Code: TemporarilyUnavailable, Description: "operator is temporarily unavailable", Status: http.StatusServiceUnavailable, Err: cause}
+	}
+	if status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests {
+		return &OAuthError{Code: TemporarilyUnavailable, Description: "operator is temporarily unavailable", Status: http.StatusServiceUnavailable, Err: cause}
+	}
+	return &OAuthError{Code: ServerError, Description: "operator returned an unexpected error", Status: http.StatusBadGateway, Err: cause}
+}
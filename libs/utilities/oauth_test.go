@@ -0,0 +1,59 @@
+package utilities
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteOAuthError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := &OAuthError{Code: TemporarilyUnavailable, Description: "try later", Status: http.StatusServiceUnavailable, RetryAfter: 1500 * time.Millisecond, Err: errors.New("breaker open")}
+	WriteOAuthError(rec, err)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	var body ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Error != TemporarilyUnavailable || body.ErrorDescription != "try later" {
+		t.Errorf("body = %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	WriteOAuthError(rec, errors.New("secret detail"))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("plain error status = %d, want 500", rec.Code)
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Error != ServerError || body.ErrorDescription == "secret detail" {
+		t.Errorf("plain error body = %+v", body)
+	}
+}
+
+func TestUpstreamError(t *testing.T) {
+	cases := []struct {
+		status     int
+		body       string
+		wantCode   string
+		wantStatus int
+	}{
+		{400, `{"error":"invalid_grant"}`, InvalidGrant, http.StatusBadRequest},
+		{400, `{"error":"invalid_request"}`, InvalidRequest, http.StatusBadRequest},
+		{401, `{"error":"invalid_client"}`, ServerError, http.StatusBadGateway},
+		{503, ``, TemporarilyUnavailable, http.StatusServiceUnavailable},
+		{500, `<html>oops</html>`, ServerError, http.StatusBadGateway},
+	}
+	for _, tc := range cases {
+		err := UpstreamError(tc.status, []byte(tc.body))
+		if err.Code != tc.wantCode || err.Status != tc.wantStatus {
+			t.Errorf("UpstreamError(%d, %s) = %s/%d, want %s/%d", tc.status, tc.body, err.Code, err.Status, tc.wantCode, tc.wantStatus)
+		}
+	}
+}
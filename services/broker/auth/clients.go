@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/graceful"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	REALM               = `Basic realm="sim-broker"`
+	assertionLeeway     = 30 * time.Second
+	assertionMaxAge     = 5 * time.Minute
+)
+
+var (
+	ErrInvalidClient      = errors.New("client authentication failed")
+	ErrUnauthorizedClient = errors.New("client is not authorized for this request")
+)
+
+type Client struct {
+	config.Client
+	keys jose.JSONWebKeySet
+}
+
+func (c *Client) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+func (c *Client) AllowsRedirect(redirectURI string) bool {
+	return len(c.RedirectURIs) == 0 || slices.Contains(c.RedirectURIs, redirectURI)
+}
+
+func (c *Client) AllowsExchangeAudience(audience string) bool {
+	return slices.Contains(c.ExchangeAudiences, audience)
+}
+
+func (c *Client) UsesMTLS() bool {
+	return c.AuthMethod == config.AuthTLSClient || c.AuthMethod == config.AuthSelfSignedTLS
+}
+
+type Authenticator struct {
+	clients   map[string]*Client
+	replay    *ReplayCache
+	clientCAs *x509.CertPool
+}
+
+// NewAuthenticator builds the client registry. clientCAs anchors tls_client_auth certificates and may be
+// nil when the broker does not serve mutual TLS.
+func NewAuthenticator(cfgs []config.Client, clientCAs *x509.CertPool) (*Authenticator, error) {
+	a := &Authenticator{
+		clients:   make(map[string]*Client, len(cfgs)),
+		replay:    NewReplayCache(),
+		clientCAs: clientCAs,
+	}
+	for _, c := range cfgs {
+		client := &Client{Client: c}
+		if len(c.JWKS) > 0 {
+			if err := json.Unmarshal(c.JWKS, &client.keys); err != nil {
+				return nil, fmt.Errorf("client %s: parse jwks: %w", c.ClientID, err)
+			}
+		}
+		a.clients[c.ClientID] = client
+	}
+	return a, nil
+}
+
+func (a *Authenticator) Client(id string) (*Client, bool) {
+	c, ok := a.clients[id]
+	return c, ok
+}
+
+// Authenticate identifies the calling client using the method it registered.
+// audiences lists the values accepted as "aud" in a private_key_jwt assertion.
+func (a *Authenticator) Authenticate(r *http.Request, audiences []string) (*Client, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+	}
+
+	if assertion := r.PostFormValue("client_assertion"); assertion != "" {
+		if r.PostFormValue("client_assertion_type") != ClientAssertionType {
+			return nil, fmt.Errorf("%w: unsupported client_assertion_type", ErrInvalidClient)
+		}
+		return a.authenticateAssertion(r.Context(), assertion, r.PostFormValue("client_id"), audiences)
+	}
+
+	if id, secret, ok := r.BasicAuth(); ok {
+		id, err1 := url.QueryUnescape(id)
+		secret, err2 := url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%w: malformed basic credentials", ErrInvalidClient)
+		}
+		return a.authenticateSecret(id, secret, config.AuthClientSecretBasic)
+	}
+
+	if secret := r.PostFormValue("client_secret"); secret != "" {
+		return a.authenticateSecret(r.PostFormValue("client_id"), secret, config.AuthClientSecretPost)
+	}
+
+	if id := r.PostFormValue("client_id"); id != "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return a.authenticateCertificate(id, r.TLS.PeerCertificates)
+	}
+
+	return nil, fmt.Errorf("%w: no client credentials", ErrInvalidClient)
+}
+
+func (a *Authenticator) authenticateSecret(id, secret, method string) (*Client, error) {
+	client, err := a.lookup(id, method)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range client.SecretHashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil {
+			return client, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: bad secret for %s", ErrInvalidClient, id)
+}
+
+func (a *Authenticator) authenticateAssertion(ctx context.Context, assertion, formClientID string, audiences []string) (*Client, error) {
+	unverified, err := jwt.PeekIssuer(assertion)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+	}
+	if formClientID != "" && subtle.ConstantTimeCompare([]byte(formClientID), []byte(unverified)) != 1 {
+		return nil, fmt.Errorf("%w: client_id does not match assertion issuer", ErrInvalidClient)
+	}
+	client, err := a.lookup(unverified, config.AuthPrivateKeyJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := jwt.Validate(ctx, assertion, jwt.StaticKeys{Set: client.keys}, jwt.Expected{
+		Issuer:   client.ClientID,
+		Audience: audiences,
+		Leeway:   assertionLeeway,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+	}
+	if claims.Subject != client.ClientID {
+		return nil, fmt.Errorf("%w: assertion sub must equal client_id", ErrInvalidClient)
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: assertion has no jti", ErrInvalidClient)
+	}
+	if claims.ExpiresAt.After(time.Now().Add(assertionMaxAge)) {
+		return nil, fmt.Errorf("%w: assertion lifetime too long", ErrInvalidClient)
+	}
+	if a.replay.Seen(client.ClientID+"|"+claims.ID, claims.ExpiresAt.Add(assertionLeeway)) {
+		return nil, fmt.Errorf("%w: assertion replayed", ErrInvalidClient)
+	}
+	return client, nil
+}
+
+// authenticateCertificate implements RFC 8705: tls_client_auth clients present a certificate issued by a
+// trusted CA with their registered subject DN or DNS SAN; self_signed_tls_client_auth clients present a
+// certificate whose key is in their registered JWKS.
+func (a *Authenticator) authenticateCertificate(id string, chain []*x509.Certificate) (*Client, error) {
+	client, ok := a.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown client %q", ErrInvalidClient, id)
+	}
+	leaf := chain[0]
+	switch client.AuthMethod {
+	case config.AuthTLSClient:
+		if a.clientCAs == nil {
+			return nil, fmt.Errorf("%w: mutual TLS is not configured", ErrInvalidClient)
+		}
+		if err := graceful.VerifyClientChain(chain, a.clientCAs); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		if !client.matchesSubject(leaf) {
+			return nil, fmt.Errorf("%w: certificate subject does not match client %s", ErrInvalidClient, id)
+		}
+	case config.AuthSelfSignedTLS:
+		now := time.Now()
+		if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+			return nil, fmt.Errorf("%w: certificate is expired or not yet valid", ErrInvalidClient)
+		}
+		if !client.holdsKey(leaf.PublicKey) {
+			return nil, fmt.Errorf("%w: certificate key is not registered for client %s", ErrInvalidClient, id)
+		}
+	default:
+		return nil, fmt.Errorf("%w: client %s must use %s", ErrInvalidClient, id, client.AuthMethod)
+	}
+	return client, nil
+}
+
+func (c *Client) matchesSubject(cert *x509.Certificate) bool {
+	if c.TLSSubjectDN != "" && cert.Subject.String() == c.TLSSubjectDN {
+		return true
+	}
+	return c.TLSSANDNS != "" && slices.Contains(cert.DNSNames, c.TLSSANDNS)
+}
+
+func (c *Client) holdsKey(pub crypto.PublicKey) bool {
+	key, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false
+	}
+	for _, k := range c.keys.Keys {
+		if key.Equal(k.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) lookup(id, method string) (*Client, error) {
+	client, ok := a.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown client %q", ErrInvalidClient, id)
+	}
+	if client.AuthMethod != method {
+		return nil, fmt.Errorf("%w: client %s must use %s", ErrInvalidClient, id, client.AuthMethod)
+	}
+	return client, nil
+}
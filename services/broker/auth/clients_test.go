@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+
+	jose "github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tokenURL = "https://broker.example.com/token"
+
+func formRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func secretClient(t *testing.T, id, method, secret string) config.Client {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	return config.Client{
+		ClientID:     id,
+		AuthMethod:   method,
+		SecretHashes: []string{string(hash)},
+		GrantTypes:   []string{config.GrantAuthorizationCode},
+	}
+}
+
+func TestAuthenticate_Secrets(t *testing.T) {
+	a, err := NewAuthenticator([]config.Client{
+		secretClient(t, "basic-app", config.AuthClientSecretBasic, "s3cret"),
+		secretClient(t, "post-app", config.AuthClientSecretPost, "p0st"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		req     func() *http.Request
+		wantID  string
+		wantErr bool
+	}{
+		{"basic ok", func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("basic-app", "s3cret")
+			return r
+		}, "basic-app", false},
+		{"basic wrong secret", func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("basic-app", "nope")
+			return r
+		}, "", true},
+		{"post ok", func() *http.Request {
+			return formRequest(url.Values{"client_id": {"post-app"}, "client_secret": {"p0st"}})
+		}, "post-app", false},
+		{"wrong method", func() *http.Request {
+			return formRequest(url.Values{"client_id": {"basic-app"}, "client_secret": {"s3cret"}})
+		}, "", true},
+		{"unknown client", func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("ghost", "s3cret")
+			return r
+		}, "", true},
+		{"no credentials", func() *http.Request {
+			return formRequest(url.Values{"client_id": {"basic-app"}})
+		}, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := a.Authenticate(tc.req(), []string{tokenURL})
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidClient) {
+					t.Fatalf("Authenticate error = %v, want ErrInvalidClient", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate error: %v", err)
+			}
+			if c.ClientID != tc.wantID {
+				t.Errorf("client = %q, want %q", c.ClientID, tc.wantID)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_PrivateKeyJWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	jwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key: &key.PublicKey, KeyID: "k1", Algorithm: string(jose.ES256), Use: "sig",
+	}}})
+	a, err := NewAuthenticator([]config.Client{{
+		ClientID:   "backend",
+		AuthMethod: config.AuthPrivateKeyJWT,
+		JWKS:       jwks,
+	}}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: "k1"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	assertion := func(sub, jti string, ttl time.Duration) string {
+		now := time.Now()
+		s, err := josejwt.Signed(signer).Claims(josejwt.Claims{
+			Issuer:   "backend",
+			Subject:  sub,
+			Audience: josejwt.Audience{tokenURL},
+			ID:       jti,
+			IssuedAt: josejwt.NewNumericDate(now),
+			Expiry:   josejwt.NewNumericDate(now.Add(ttl)),
+		}).Serialize()
+		if err != nil {
+			t.Fatalf("sign assertion: %v", err)
+		}
+		return s
+	}
+	request := func(token string) *http.Request {
+		return formRequest(url.Values{
+			"client_assertion_type": {ClientAssertionType},
+			"client_assertion":      {token},
+		})
+	}
+
+	ok := assertion("backend", "a-1", time.Minute)
+	if _, err := a.Authenticate(request(ok), []string{tokenURL}); err != nil {
+		t.Fatalf("valid assertion rejected: %v", err)
+	}
+	if _, err := a.Authenticate(request(ok), []string{tokenURL}); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("replayed assertion error = %v, want ErrInvalidClient", err)
+	}
+
+	bad := map[string]string{
+		"wrong sub":      assertion("other", "a-2", time.Minute),
+		"missing jti":    assertion("backend", "", time.Minute),
+		"too long":       assertion("backend", "a-3", time.Hour),
+		"wrong audience": assertion("backend", "a-4", time.Minute),
+	}
+	for name, token := range bad {
+		aud := []string{tokenURL}
+		if name == "wrong audience" {
+			aud = []string{"https://elsewhere.example.com/token"}
+		}
+		if _, err := a.Authenticate(request(token), aud); !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("%s: error = %v, want ErrInvalidClient", name, err)
+		}
+	}
+}
+
+func issueCert(t *testing.T, cn string, dns []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              dns,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func TestAuthenticate_Certificates(t *testing.T) {
+	ca, caKey := issueCert(t, "test CA", nil, nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	partner, _ := issueCert(t, "partner", []string{"partner.example.com"}, ca, caKey)
+	other, _ := issueCert(t, "other", []string{"other.example.com"}, ca, caKey)
+	self, selfKey := issueCert(t, "device", nil, nil, nil)
+	stranger, _ := issueCert(t, "stranger", nil, nil, nil)
+
+	jwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &selfKey.PublicKey, KeyID: "d1"}}})
+	a, err := NewAuthenticator([]config.Client{
+		{ClientID: "partner", AuthMethod: config.AuthTLSClient, TLSSANDNS: "partner.example.com"},
+		{ClientID: "device", AuthMethod: config.AuthSelfSignedTLS, JWKS: jwks},
+	}, pool)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	request := func(id string, cert *x509.Certificate) *http.Request {
+		r := formRequest(url.Values{"client_id": {id}})
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		return r
+	}
+	cases := []struct {
+		name    string
+		req     *http.Request
+		wantErr bool
+	}{
+		{"tls_client_auth ok", request("partner", partner), false},
+		{"tls_client_auth wrong subject", request("partner", other), true},
+		{"tls_client_auth untrusted", request("partner", stranger), true},
+		{"self-signed ok", request("device", self), false},
+		{"self-signed unregistered key", request("device", stranger), true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := a.Authenticate(tc.req, []string{tokenURL})
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidClient) {
+					t.Fatalf("Authenticate error = %v, want ErrInvalidClient", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate error: %v", err)
+			}
+			if !c.UsesMTLS() {
+				t.Errorf("client %s should use mutual TLS", c.ClientID)
+			}
+		})
+	}
+}
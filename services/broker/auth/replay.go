@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const replaySweepInterval = time.Minute
+
+type ReplayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{seen: make(map[string]time.Time)}
+}
+
+// Seen records id until expiresAt and reports whether it was already recorded.
+func (c *ReplayCache) Seen(id string, expiresAt time.Time) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.nextSweep) {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.nextSweep = now.Add(replaySweepInterval)
+	}
+	if exp, ok := c.seen[id]; ok && !now.After(exp) {
+		return true
+	}
+	c.seen[id] = expiresAt
+	return false
+}
@@ -0,0 +1,37 @@
+package authz
+
+import (
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+)
+
+const (
+	PendingTTL = 10 * time.Minute
+	CodeTTL    = time.Minute
+)
+
+// Pending is an /authorize request waiting for the telco to call back.
+type Pending struct {
+	ClientID      string
+	RedirectURI   string
+	State         string
+	CodeChallenge string
+	Scope         string
+	Nonce         string
+	Phone         string
+	Telco         config.Telco
+	TelcoVerifier string
+	CallbackURL   string
+}
+
+// Code is a broker authorization code: the verified grant plus what /token must check before issuing it.
+type Code struct {
+	ClientID      string
+	RedirectURI   string
+	CodeChallenge string
+	Nonce         string
+	Grant         refresh.Token
+	NotAfter      time.Time
+}
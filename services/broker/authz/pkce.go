@@ -0,0 +1,30 @@
+package authz
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+const MethodS256 = "S256"
+
+var verifierRegex = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// NewVerifier returns a random RFC 7636 code_verifier.
+func NewVerifier() string {
+	return RandomString(32)
+}
+
+func S256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE reports whether verifier is well formed and hashes to challenge.
+func VerifyPKCE(verifier, challenge string) bool {
+	if !verifierRegex.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(S256(verifier)), []byte(challenge)) == 1
+}
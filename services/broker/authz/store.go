@@ -0,0 +1,69 @@
+package authz
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type entry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// Store holds short-lived, single-use values under random keys.
+type Store[T any] struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	items     map[string]entry[T]
+	nextSweep time.Time
+}
+
+func NewStore[T any](ttl time.Duration) *Store[T] {
+	return &Store[T]{ttl: ttl, items: make(map[string]entry[T])}
+}
+
+// Put stores v and returns the key that redeems it.
+func (s *Store[T]) Put(v T) string {
+	key := RandomString(32)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	s.items[key] = entry[T]{value: v, expiresAt: now.Add(s.ttl)}
+	return key
+}
+
+// Take removes and returns the value stored under key, if it has not expired.
+func (s *Store[T]) Take(key string) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	delete(s.items, key)
+	if !ok || time.Now().After(e.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (s *Store[T]) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for k, e := range s.items {
+		if now.After(e.expiresAt) {
+			delete(s.items, k)
+		}
+	}
+	s.nextSweep = now.Add(sweepInterval)
+}
+
+func RandomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
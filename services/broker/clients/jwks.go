@@ -0,0 +1,225 @@
+package clients
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	jwksTTL           = 10 * time.Minute
+	jwksMinTTL        = time.Minute
+	jwksMaxStale      = time.Hour
+	jwksRetryInterval = 30 * time.Second
+	jwksFetchTimeout  = 5 * time.Second
+)
+
+type jwksFetcher func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error)
+
+type JWKSCache struct {
+	url      string
+	fetch    jwksFetcher
+	ttl      time.Duration
+	timeout  time.Duration
+	maxStale time.Duration
+	logger   *slog.Logger
+
+	mu        sync.RWMutex
+	set       jose.JSONWebKeySet
+	loaded    bool
+	fetchedAt time.Time
+	expiresAt time.Time
+
+	group     singleflight.Group
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	refreshes atomic.Uint64
+	failures  atomic.Uint64
+
+	started  atomic.Bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type JWKSStats struct {
+	Telco      string    `json:"telco,omitempty"`
+	URL        string    `json:"url"`
+	Loaded     bool      `json:"loaded"`
+	FetchedAt  time.Time `json:"fetched_at,omitzero"`
+	AgeSeconds float64   `json:"age_seconds"`
+	Stale      bool      `json:"stale"`
+	Hits       uint64    `json:"hits"`
+	Misses     uint64    `json:"misses"`
+	Refreshes  uint64    `json:"refreshes"`
+	Failures   uint64    `json:"failures"`
+}
+
+func NewJWKSCache(url string, fetch jwksFetcher, logger *slog.Logger) *JWKSCache {
+	return &JWKSCache{
+		url:      url,
+		fetch:    fetch,
+		ttl:      jwksTTL,
+		timeout:  jwksFetchTimeout,
+		maxStale: jwksMaxStale,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *JWKSCache) Keys(ctx context.Context) (jose.JSONWebKeySet, error) {
+	now := time.Now()
+	c.mu.RLock()
+	set, loaded, expiresAt := c.set, c.loaded, c.expiresAt
+	c.mu.RUnlock()
+
+	if loaded && now.Before(expiresAt) {
+		c.hits.Add(1)
+		return set, nil
+	}
+
+	c.misses.Add(1)
+	fresh, err := c.Refresh(ctx)
+	if err == nil {
+		return fresh, nil
+	}
+	if loaded && now.Before(expiresAt.Add(c.maxStale)) {
+		c.logger.Warn("jwks refresh failed, serving stale keys", "url", c.url, "error", err)
+		return set, nil
+	}
+	return jose.JSONWebKeySet{}, err
+}
+
+// Refresh fetches the key set, sharing one fetch between concurrent callers. The fetch is detached from
+// ctx so that one caller giving up does not fail the others waiting on it.
+func (c *JWKSCache) Refresh(ctx context.Context) (jose.JSONWebKeySet, error) {
+	ch := c.group.DoChan(c.url, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+		defer cancel()
+		c.refreshes.Add(1)
+		set, maxAge, err := c.fetch(ctx)
+		if err != nil {
+			c.failures.Add(1)
+			return jose.JSONWebKeySet{}, err
+		}
+		c.store(set, maxAge)
+		return set, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return jose.JSONWebKeySet{}, res.Err
+		}
+		return res.Val.(jose.JSONWebKeySet), nil
+	case <-ctx.Done():
+		return jose.JSONWebKeySet{}, ctx.Err()
+	}
+}
+
+func (c *JWKSCache) Start() {
+	if c.started.CompareAndSwap(false, true) {
+		go c.run()
+	}
+}
+
+func (c *JWKSCache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	if c.started.Load() {
+		<-c.done
+	}
+}
+
+func (c *JWKSCache) Stats() JWKSStats {
+	now := time.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats := JWKSStats{
+		URL:       c.url,
+		Loaded:    c.loaded,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Refreshes: c.refreshes.Load(),
+		Failures:  c.failures.Load(),
+	}
+	if c.loaded {
+		stats.FetchedAt = c.fetchedAt
+		stats.AgeSeconds = now.Sub(c.fetchedAt).Seconds()
+		stats.Stale = !now.Before(c.expiresAt)
+	}
+	return stats
+}
+
+func (c *JWKSCache) run() {
+	defer close(c.done)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		_, err := c.Refresh(ctx)
+		cancel()
+		if err != nil {
+			c.logger.Warn("background jwks refresh failed", "url", c.url, "error", err)
+			timer.Reset(jwksRetryInterval)
+			continue
+		}
+		timer.Reset(c.nextRefresh(time.Now()))
+	}
+}
+
+// Refresh ahead of expiry so requests keep hitting a warm cache.
+func (c *JWKSCache) nextRefresh(now time.Time) time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	lifetime := c.expiresAt.Sub(c.fetchedAt)
+	next := c.fetchedAt.Add(lifetime * 4 / 5).Sub(now)
+	if next < jwksRetryInterval {
+		return jwksRetryInterval
+	}
+	return next
+}
+
+func (c *JWKSCache) store(set jose.JSONWebKeySet, maxAge time.Duration) {
+	ttl := c.ttl
+	if maxAge > 0 {
+		ttl = max(maxAge, jwksMinTTL)
+	}
+	now := time.Now()
+	c.mu.Lock()
+	c.set = set
+	c.loaded = true
+	c.fetchedAt = now
+	c.expiresAt = now.Add(ttl)
+	c.mu.Unlock()
+}
+
+func parseMaxAge(h http.Header) time.Duration {
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+func TestJWKSCache_ServesStaleOnError(t *testing.T) {
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{KeyID: "k1"}}}
+	fail := false
+	fetches := 0
+	cache := NewJWKSCache("http://telco/jwks", func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		fetches++
+		if fail {
+			return jose.JSONWebKeySet{}, 0, errors.New("telco down")
+		}
+		return set, 0, nil
+	}, slog.New(slog.DiscardHandler))
+
+	if _, err := cache.Keys(context.Background()); err != nil {
+		t.Fatalf("first Keys error: %v", err)
+	}
+	if _, err := cache.Keys(context.Background()); err != nil {
+		t.Fatalf("second Keys error: %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+
+	fail = true
+	cache.mu.Lock()
+	cache.expiresAt = time.Now().Add(-time.Minute)
+	cache.mu.Unlock()
+
+	got, err := cache.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Keys within stale window error: %v", err)
+	}
+	if len(got.Keys) != 1 || got.Keys[0].KeyID != "k1" {
+		t.Errorf("Keys returned %+v, want stale set", got)
+	}
+
+	cache.mu.Lock()
+	cache.expiresAt = time.Now().Add(-cache.maxStale - time.Minute)
+	cache.mu.Unlock()
+	if _, err := cache.Keys(context.Background()); err == nil {
+		t.Error("Keys expected error once the stale window has passed")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Failures != 2 || !stats.Stale {
+		t.Errorf("Stats = %+v, want 1 hit, 3 misses, 2 failures, stale", stats)
+	}
+}
+
+func TestJWKSCache_CancelledCallerDoesNotFailOthers(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var once sync.Once
+	var aborted atomic.Bool
+	cache := NewJWKSCache("http://telco/jwks", func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		once.Do(func() { close(started) })
+		select {
+		case <-release:
+			return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{KeyID: "k1"}}}, 0, nil
+		case <-ctx.Done():
+			aborted.Store(true)
+			return jose.JSONWebKeySet{}, 0, ctx.Err()
+		}
+	}, slog.New(slog.DiscardHandler))
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Refresh(first)
+		firstErr <- err
+	}()
+	<-started
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cache.Refresh(context.Background())
+		second <- err
+	}()
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("waiting caller error = %v, want the fetched keys", err)
+	}
+	if aborted.Load() {
+		t.Error("cancelling the first caller aborted the shared fetch")
+	}
+}
+
+func TestJWKSCache_HonorsMaxAge(t *testing.T) {
+	cache := NewJWKSCache("http://telco/jwks", func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		return jose.JSONWebKeySet{}, 2 * time.Hour, nil
+	}, slog.New(slog.DiscardHandler))
+
+	if _, err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := cache.expiresAt.Sub(cache.fetchedAt); ttl != 2*time.Hour {
+		t.Errorf("ttl = %v, want 2h", ttl)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	cases := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"no-store", 0},
+		{"public, max-age=300", 5 * time.Minute},
+		{"MAX-AGE=60, must-revalidate", time.Minute},
+		{"max-age=abc", 0},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		h.Set("Cache-Control", c.header)
+		if got := parseMaxAge(h); got != c.want {
+			t.Errorf("parseMaxAge(%q) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/router"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+)
+
+const (
+	maxIdleConns        = 100
+	maxIdleConnsPerHost = 20
+	idleConnTimeout     = 90 * time.Second
+)
+
+// ErrNotConfigured is returned for a telco the current snapshot has no client for.
+var ErrNotConfigured = errors.New("no client registered for telco")
+
+// Registry holds the current prefix router and one TelcoClient per telco it routes to. Both are published
+// together as one snapshot, so a lookup never sees a router whose telcos have no client.
+type Registry struct {
+	transport *http.Transport
+	logger    *slog.Logger
+	state     atomic.Pointer[registryState]
+
+	mu      sync.Mutex
+	started bool
+}
+
+type registryState struct {
+	routes  *router.Router
+	clients map[string]*TelcoClient
+}
+
+func NewRegistry(prefixMap map[string]config.Telco, logger *slog.Logger) *Registry {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+
+	reg := &Registry{
+		transport: transport,
+		logger:    logger,
+	}
+	reg.state.Store(&registryState{routes: router.New(nil), clients: map[string]*TelcoClient{}})
+	reg.Update(router.New(prefixMap))
+	return reg
+}
+
+// Routes returns the router currently in use.
+func (r *Registry) Routes() *router.Router {
+	return r.state.Load().routes
+}
+
+// Route finds the telco serving phone.
+func (r *Registry) Route(phone string) (config.Telco, error) {
+	return r.Routes().Match(phone)
+}
+
+// Resolve finds the telco serving phone and its client in the same snapshot, so an Update in between
+// cannot leave the request with a telco that has no client.
+func (r *Registry) Resolve(phone string) (config.Telco, *TelcoClient, error) {
+	st := r.state.Load()
+	telco, err := st.routes.Match(phone)
+	if err != nil {
+		return config.Telco{}, nil, err
+	}
+	tc, err := st.client(telco)
+	return telco, tc, err
+}
+
+// Update swaps in routes, creating clients for telcos it introduces or whose configuration changed in any
+// way, and stopping the JWKS refresh of the clients it replaces once the new snapshot is live. Requests
+// already holding a replaced client can still finish.
+func (r *Registry) Update(routes *router.Router) {
+	r.mu.Lock()
+	prev := r.state.Load()
+	next := &registryState{routes: routes, clients: make(map[string]*TelcoClient)}
+	for _, telco := range routes.Telcos() {
+		key := registryKey(telco)
+		if _, ok := next.clients[key]; ok {
+			continue
+		}
+		if old, ok := prev.clients[key]; ok && reflect.DeepEqual(old.telco, telco) {
+			next.clients[key] = old
+			continue
+		}
+		tc := New(telco, r.transport, r.logger)
+		if r.started {
+			tc.jwks.Start()
+		}
+		next.clients[key] = tc
+	}
+	var replaced []*TelcoClient
+	for key, tc := range prev.clients {
+		if next.clients[key] != tc {
+			replaced = append(replaced, tc)
+		}
+	}
+	r.state.Store(next)
+	r.mu.Unlock()
+
+	// Stop waits for an in-flight refresh, so it runs outside the lock.
+	for _, tc := range replaced {
+		tc.jwks.Stop()
+	}
+}
+
+func (r *Registry) Get(telco config.Telco) (*TelcoClient, error) {
+	return r.state.Load().client(telco)
+}
+
+func (s *registryState) client(telco config.Telco) (*TelcoClient, error) {
+	tc, ok := s.clients[registryKey(telco)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotConfigured, telco.BaseURL)
+	}
+	return tc, nil
+}
+
+func (r *Registry) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = true
+	for _, tc := range r.state.Load().clients {
+		tc.jwks.Start()
+	}
+}
+
+func (r *Registry) Stats() []JWKSStats {
+	clients := r.state.Load().clients
+	stats := make([]JWKSStats, 0, len(clients))
+	for _, tc := range clients {
+		st := tc.jwks.Stats()
+		st.Telco = tc.Name
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
+
+func (r *Registry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tc := range r.state.Load().clients {
+		tc.jwks.Stop()
+	}
+	r.transport.CloseIdleConnections()
+}
+
+func registryKey(telco config.Telco) string {
+	return telco.BaseURL + "|" + telco.ClientID
+}
@@ -0,0 +1,155 @@
+package clients
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/router"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+func TestRegistry_SharesClientPerTelco(t *testing.T) {
+	partner := config.Telco{BaseURL: "http://partner", ClientID: "p", ClientSecret: "ps"}
+	cellcom := config.Telco{BaseURL: "http://cellcom", ClientID: "c", ClientSecret: "cs"}
+	reg := NewRegistry(map[string]config.Telco{
+		"97254": partner,
+		"97255": partner,
+		"97252": cellcom,
+	}, slog.New(slog.DiscardHandler))
+	defer reg.Close()
+
+	a, err := reg.Get(partner)
+	if err != nil {
+		t.Fatalf("Get(partner) error: %v", err)
+	}
+	b, err := reg.Get(partner)
+	if err != nil {
+		t.Fatalf("Get(partner) error: %v", err)
+	}
+	if a != b {
+		t.Error("Get(partner) returned different clients; want the same instance")
+	}
+
+	c, err := reg.Get(cellcom)
+	if err != nil {
+		t.Fatalf("Get(cellcom) error: %v", err)
+	}
+	if a == c {
+		t.Error("Get(cellcom) returned the partner client")
+	}
+	if n := len(reg.state.Load().clients); n != 2 {
+		t.Errorf("registry holds %d clients, want 2", n)
+	}
+}
+
+func TestRegistry_UnknownTelco(t *testing.T) {
+	reg := NewRegistry(map[string]config.Telco{}, slog.New(slog.DiscardHandler))
+	defer reg.Close()
+
+	if _, err := reg.Get(config.Telco{BaseURL: "http://unknown"}); err == nil {
+		t.Error("Get expected error for unregistered telco")
+	}
+}
+
+func TestRegistry_Update(t *testing.T) {
+	partner := config.Telco{BaseURL: "http://partner", ClientID: "p", ClientSecret: "ps"}
+	cellcom := config.Telco{BaseURL: "http://cellcom", ClientID: "c", ClientSecret: "cs"}
+	reg := NewRegistry(map[string]config.Telco{"97254": partner}, slog.New(slog.DiscardHandler))
+	defer reg.Close()
+	before, _ := reg.Get(partner)
+
+	reg.Update(router.New(map[string]config.Telco{"97252": cellcom}))
+	if got, err := reg.Route("+972521234567"); err != nil || got.BaseURL != cellcom.BaseURL {
+		t.Errorf("Route after update = %+v, %v; want cellcom", got, err)
+	}
+	if _, err := reg.Get(cellcom); err != nil {
+		t.Errorf("Get(cellcom) after update: %v", err)
+	}
+	if _, err := reg.Get(partner); err == nil {
+		t.Error("Get(partner) still succeeds after partner was removed")
+	}
+
+	rotated := partner
+	rotated.ClientSecret = "ps2"
+	reg.Update(router.New(map[string]config.Telco{"97254": partner}))
+	reg.Update(router.New(map[string]config.Telco{"97254": rotated}))
+	after, _ := reg.Get(rotated)
+	if after == before || after.ClientSecret != "ps2" {
+		t.Error("client was not rebuilt after its secret changed")
+	}
+
+	renamed := rotated
+	renamed.Name = "partner-il"
+	reg.Update(router.New(map[string]config.Telco{"97254": renamed}))
+	kept, _ := reg.Get(renamed)
+	if kept == after || kept.Name != "partner-il" {
+		t.Error("client was not rebuilt after its name changed")
+	}
+	reg.Update(router.New(map[string]config.Telco{"97254": renamed, "97258": renamed}))
+	if got, _ := reg.Get(renamed); got != kept {
+		t.Error("client was rebuilt although its configuration did not change")
+	}
+}
+
+func TestRegistry_ResolveUsesOneSnapshot(t *testing.T) {
+	partner := config.Telco{Name: "partner", BaseURL: "http://partner", ClientID: "p", ClientSecret: "ps"}
+	reg := NewRegistry(map[string]config.Telco{"97254": partner}, slog.New(slog.DiscardHandler))
+	defer reg.Close()
+
+	telco, tc, err := reg.Resolve("+972541234567")
+	if err != nil || telco.Name != "partner" || tc == nil || tc.Name != "partner" {
+		t.Fatalf("Resolve = %+v, %v, %v; want the partner telco and its client", telco, tc, err)
+	}
+	if _, _, err := reg.Resolve("+15551234567"); err == nil {
+		t.Error("Resolve expected error for an unrouted phone")
+	}
+}
+
+func TestRegistry_UpdateStopsReplacedClientsOutsideTheLock(t *testing.T) {
+	partner := config.Telco{BaseURL: "http://partner", ClientID: "p", ClientSecret: "ps"}
+	cellcom := config.Telco{BaseURL: "http://cellcom", ClientID: "c", ClientSecret: "cs"}
+	reg := NewRegistry(map[string]config.Telco{"97254": partner}, slog.New(slog.DiscardHandler))
+	defer reg.Close()
+
+	old, _ := reg.Get(partner)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	old.jwks.fetch = func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		once.Do(func() { close(started) })
+		<-release
+		return jose.JSONWebKeySet{}, 0, nil
+	}
+	old.jwks.Start()
+	<-started
+
+	first := make(chan struct{})
+	go func() {
+		reg.Update(router.New(map[string]config.Telco{"97252": cellcom}))
+		close(first)
+	}()
+	second := make(chan struct{})
+	go func() {
+		for {
+			if _, err := reg.Get(cellcom); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		reg.Update(router.New(map[string]config.Telco{"97252": cellcom, "97253": cellcom}))
+		close(second)
+	}()
+
+	select {
+	case <-second:
+	case <-time.After(2 * time.Second):
+		t.Error("Update blocked while a replaced client was still refreshing its JWKS")
+	}
+	close(release)
+	<-first
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
-	"sync"
+	"strconv"
 	"time"
 
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
@@ -16,64 +19,97 @@ import (
 
 	jose "github.com/go-jose/go-jose/v4"
 	"github.com/sony/gobreaker"
-	"golang.org/x/sync/singleflight"
 	"golang.org/x/time/rate"
 )
 
-const (
-	jwksTTL   = 10 * time.Minute
-	rateLimit = 5
-	rateBurst = 10
-)
+const jwksPath = "/.well-known/jwks.json"
 
 var (
-	jwksMu    sync.RWMutex
-	jwksCache = make(map[string]*cachedSet)
-	jwksGroup singleflight.Group
+	errUnavailable = utilities.NewOAuthError(utilities.TemporarilyUnavailable, "operator is temporarily unavailable", http.StatusServiceUnavailable)
+	errTimeout     = utilities.NewOAuthError(utilities.TemporarilyUnavailable, "operator did not respond in time", http.StatusGatewayTimeout)
+	errBadGateway  = utilities.NewOAuthError(utilities.ServerError, "operator could not be reached", http.StatusBadGateway)
 )
 
-type cachedSet struct {
-	set       jose.JSONWebKeySet
-	fetchedAt time.Time
+type jwksResult struct {
+	set    jose.JSONWebKeySet
+	maxAge time.Duration
+}
+
+// Tokens is a telco token response. IDToken is empty unless the telco returned one.
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
 }
 
 type TelcoClient struct {
+	Name         string
 	BaseURL      string
 	ClientID     string
 	ClientSecret string
 	HTTP         *http.Client
+	telco        config.Telco
+	resilience   config.Resilience
 	limiter      *rate.Limiter
 	breaker      *gobreaker.CircuitBreaker
+	jwks         *JWKSCache
 }
 
-func New(cfgTelco config.Telco) *TelcoClient {
+// New builds the client for a telco, applying its resilience settings (defaults where unset).
+func New(cfgTelco config.Telco, transport http.RoundTripper, logger *slog.Logger) *TelcoClient {
+	res := cfgTelco.Resilience.WithDefaults()
 	cbSettings := gobreaker.Settings{
 		Name:        cfgTelco.BaseURL,
-		MaxRequests: 1,
-		Interval:    time.Minute,
-		Timeout:     30 * time.Second,
+		MaxRequests: res.Breaker.MaxRequests,
+		Interval:    res.Breaker.Interval,
+		Timeout:     res.Breaker.Timeout,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures >= 3
+			return counts.ConsecutiveFailures >= res.Breaker.ConsecutiveFailures
+		},
+		IsSuccessful: func(err error) bool {
+			return !isBreakerFailure(err, res.Breaker)
 		},
 	}
-	return &TelcoClient{
+	tc := &TelcoClient{
+		Name:         cfgTelco.Name,
 		BaseURL:      cfgTelco.BaseURL,
 		ClientID:     cfgTelco.ClientID,
 		ClientSecret: cfgTelco.ClientSecret,
 		HTTP: &http.Client{
-			Timeout:   5 * time.Second,
-			Transport: utilities.RequestIDTransport(nil),
+			Timeout:   res.Timeout,
+			Transport: utilities.RequestIDTransport(transport),
 		},
-		limiter: rate.NewLimiter(rate.Limit(rateLimit), rateBurst),
-		breaker: gobreaker.NewCircuitBreaker(cbSettings),
+		telco:      cfgTelco,
+		resilience: res,
+		limiter:    rate.NewLimiter(rate.Limit(res.RateLimit), res.RateBurst),
+		breaker:    gobreaker.NewCircuitBreaker(cbSettings),
 	}
+	jwksURL := cfgTelco.BaseURL + jwksPath
+	tc.jwks = NewJWKSCache(jwksURL, func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		return tc.FetchJWKs(ctx, jwksURL)
+	}, logger)
+	tc.jwks.ttl = res.JWKSTTL
+	tc.jwks.timeout = res.Timeout
+	return tc
+}
+
+func (t *TelcoClient) JWKS() *JWKSCache {
+	return t.jwks
 }
 
-func (t *TelcoClient) ExchangeCode(ctx context.Context, form url.Values) (string, error) {
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+// ExchangeCode redeems an authorization code at the telco. Failures are *utilities.OAuthError values:
+// the telco's own OAuth error when it sent one, 503 with a retry hint while the circuit breaker is open,
+// 504 when the telco is too slow and 502 for anything else.
+func (t *TelcoClient) ExchangeCode(ctx context.Context, form url.Values) (Tokens, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, t.resilience.Timeout)
 	defer cancel()
 	if err := t.limiter.Wait(ctxWithTimeout); err != nil {
-		return "", fmt.Errorf("rate limit wait failed: %w", err)
+		// Wait gives up early, with its own error rather than DeadlineExceeded, when the next token would
+		// only arrive after the deadline; short of the caller cancelling, every failure is a timeout.
+		err = fmt.Errorf("rate limit wait failed: %w", err)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return Tokens{}, errUnavailable.Wrap(err)
+		}
+		return Tokens{}, errTimeout.Wrap(err)
 	}
 
 	res, err := t.breaker.Execute(func() (any, error) {
@@ -97,68 +133,96 @@ func (t *TelcoClient) ExchangeCode(ctx context.Context, form url.Values) (string
 
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
-			return "", fmt.Errorf("telco error %d: %s", resp.StatusCode, body)
+			oe := utilities.UpstreamError(resp.StatusCode, body)
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+				oe.RetryAfter = time.Duration(secs) * time.Second
+			}
+			return "", &statusError{status: resp.StatusCode, err: oe}
 		}
 
-		var out struct {
-			AccessToken string `json:"access_token"`
-		}
+		var out Tokens
 		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-			return "", err
+			return "", fmt.Errorf("decode token response: %w", err)
 		}
-		return out.AccessToken, nil
+		return out, nil
 	})
 
 	if err != nil {
-		return "", err
+		return Tokens{}, t.upstreamError(err)
 	}
 
-	token, ok := res.(string)
+	tokens, ok := res.(Tokens)
 	if !ok {
-		return "", fmt.Errorf("unexpected response from circuit breaker")
+		return Tokens{}, fmt.Errorf("unexpected response from circuit breaker")
 	}
-	return token, nil
+	return tokens, nil
+}
+
+// statusError is a non-200 telco response. It keeps the telco's status for breaker classification and
+// unwraps to the error reported for it.
+type statusError struct {
+	status int
+	err    error
 }
 
-func (t *TelcoClient) GetJWKs(ctx context.Context, jwksURL string) (jose.JSONWebKeySet, error) {
-	jwksMu.RLock()
-	if cs, ok := jwksCache[jwksURL]; ok && time.Since(cs.fetchedAt) < jwksTTL {
-		set := cs.set
-		jwksMu.RUnlock()
-		return set, nil
+func (e *statusError) Error() string {
+	return fmt.Sprintf("telco status %d: %v", e.status, e.err)
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// isBreakerFailure decides whether a failed call counts against the breaker: telco responses only when
+// their status is listed in the breaker's failure statuses (5xx by default), so a user's bad code does
+// not open the breaker for everyone. Calls the caller abandoned are not the telco's fault; timeouts,
+// connection errors and unreadable responses are.
+func isBreakerFailure(err error, b config.Breaker) bool {
+	var se *statusError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &se):
+		return b.IsFailure(se.status)
+	case errors.Is(err, context.Canceled):
+		return false
 	}
-	jwksMu.RUnlock()
+	return true
+}
 
-	v, err, _ := jwksGroup.Do(jwksURL, func() (any, error) {
-		set, err := t.FetchJWKs(ctx, jwksURL)
-		if err != nil {
-			return jose.JSONWebKeySet{}, err
-		}
-		t.UpdateCache(jwksURL, set)
-		return set, nil
-	})
-	if err != nil {
-		return jose.JSONWebKeySet{}, err
+// upstreamError classifies a failed telco call, keeping err as the cause.
+func (t *TelcoClient) upstreamError(err error) error {
+	var oe *utilities.OAuthError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &oe):
+		return err
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		out := errUnavailable.Wrap(err)
+		out.RetryAfter = t.resilience.Breaker.Timeout
+		return out
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout.Wrap(err)
 	}
-	return v.(jose.JSONWebKeySet), nil
+	return errBadGateway.Wrap(err)
 }
 
-func (t *TelcoClient) FetchJWKs(ctx context.Context, jwksURL string) (jose.JSONWebKeySet, error) {
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+func (t *TelcoClient) FetchJWKs(ctx context.Context, jwksURL string) (jose.JSONWebKeySet, time.Duration, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, t.resilience.Timeout)
 	defer cancel()
 	if err := t.limiter.Wait(ctxWithTimeout); err != nil {
-		return jose.JSONWebKeySet{}, fmt.Errorf("rate limit wait failed: %w", err)
+		return jose.JSONWebKeySet{}, 0, fmt.Errorf("rate limit wait failed: %w", err)
 	}
 
 	res, err := t.breaker.Execute(func() (any, error) {
 		req, err := http.NewRequestWithContext(ctxWithTimeout, "GET", jwksURL, nil)
 		if err != nil {
-			return jose.JSONWebKeySet{}, fmt.Errorf("create jwks request: %w", err)
+			return nil, fmt.Errorf("create jwks request: %w", err)
 		}
 		req.Header.Set("Accept", "application/json")
 		resp, err := t.HTTP.Do(req)
 		if err != nil {
-			return jose.JSONWebKeySet{}, fmt.Errorf("fetch jwks: %w", err)
+			return nil, fmt.Errorf("fetch jwks: %w", err)
 		}
 
 		defer func() {
@@ -167,31 +231,25 @@ func (t *TelcoClient) FetchJWKs(ctx context.Context, jwksURL string) (jose.JSONW
 		}()
 
 		if resp.StatusCode != http.StatusOK {
-			return jose.JSONWebKeySet{}, fmt.Errorf("jwks fetch status: %d", resp.StatusCode)
+			return nil, &statusError{status: resp.StatusCode, err: errors.New("jwks fetch failed")}
 		}
 		data, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return jose.JSONWebKeySet{}, fmt.Errorf("read jwks: %w", err)
+			return nil, fmt.Errorf("read jwks: %w", err)
 		}
 		var set jose.JSONWebKeySet
 		if err := json.Unmarshal(data, &set); err != nil {
-			return jose.JSONWebKeySet{}, fmt.Errorf("parse jwks: %w", err)
+			return nil, fmt.Errorf("parse jwks: %w", err)
 		}
-		return set, nil
+		return jwksResult{set: set, maxAge: parseMaxAge(resp.Header)}, nil
 	})
 
 	if err != nil {
-		return jose.JSONWebKeySet{}, err
+		return jose.JSONWebKeySet{}, 0, err
 	}
-	set, ok := res.(jose.JSONWebKeySet)
+	out, ok := res.(jwksResult)
 	if !ok {
-		return jose.JSONWebKeySet{}, fmt.Errorf("unexpected response type from circuit breaker")
+		return jose.JSONWebKeySet{}, 0, fmt.Errorf("unexpected response type from circuit breaker")
 	}
-	return set, nil
-}
-
-func (t *TelcoClient) UpdateCache(jwksURL string, set jose.JSONWebKeySet) {
-	jwksMu.Lock()
-	jwksCache[jwksURL] = &cachedSet{set: set, fetchedAt: time.Now()}
-	jwksMu.Unlock()
+	return out.set, out.maxAge, nil
 }
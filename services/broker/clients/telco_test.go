@@ -0,0 +1,165 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestExchangeCode_Errors(t *testing.T) {
+	cases := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantCode   string
+		wantStatus int
+	}{
+		{"invalid grant", func(w http.ResponseWriter, r *http.Request) {
+			utilities.WriteJSONError(w, utilities.InvalidGrant, "code expired", http.StatusBadRequest)
+		}, utilities.InvalidGrant, http.StatusBadRequest},
+		{"unavailable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, utilities.TemporarilyUnavailable, http.StatusServiceUnavailable},
+		{"bad credentials", func(w http.ResponseWriter, r *http.Request) {
+			utilities.WriteJSONError(w, utilities.InvalidClient, "", http.StatusUnauthorized)
+		}, utilities.ServerError, http.StatusBadGateway},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}, utilities.TemporarilyUnavailable, http.StatusGatewayTimeout},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+			tel := New(config.Telco{BaseURL: srv.URL}, nil, slog.New(slog.DiscardHandler))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			_, err := tel.ExchangeCode(ctx, url.Values{})
+			var oe *utilities.OAuthError
+			if !errors.As(err, &oe) {
+				t.Fatalf("error = %v, want *utilities.OAuthError", err)
+			}
+			if oe.Code != tc.wantCode || oe.Status != tc.wantStatus {
+				t.Errorf("got %s/%d, want %s/%d", oe.Code, oe.Status, tc.wantCode, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestExchangeCode_ThrottledPastDeadline(t *testing.T) {
+	tel := New(config.Telco{BaseURL: "http://telco.invalid", Resilience: config.Resilience{RateLimit: 0.001, RateBurst: 1}}, nil, slog.New(slog.DiscardHandler))
+	tel.limiter.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := tel.ExchangeCode(ctx, url.Values{})
+	var oe *utilities.OAuthError
+	if !errors.As(err, &oe) || oe.Code != utilities.TemporarilyUnavailable || oe.Status != http.StatusGatewayTimeout {
+		t.Fatalf("error = %v, want 504 temporarily_unavailable", err)
+	}
+}
+
+func TestExchangeCode_BreakerOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	tel := New(config.Telco{BaseURL: srv.URL}, nil, slog.New(slog.DiscardHandler))
+
+	var err error
+	for range 4 {
+		_, err = tel.ExchangeCode(context.Background(), url.Values{})
+	}
+	var oe *utilities.OAuthError
+	if !errors.As(err, &oe) || oe.Status != http.StatusServiceUnavailable || oe.RetryAfter <= 0 {
+		t.Fatalf("error = %v, want 503 with Retry-After once the breaker is open", err)
+	}
+}
+
+func TestNew_Resilience(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	tel := New(config.Telco{BaseURL: srv.URL, Resilience: config.Resilience{
+		Timeout: 50 * time.Millisecond,
+		Breaker: config.Breaker{ConsecutiveFailures: 2, Timeout: 7 * time.Second},
+	}}, nil, slog.New(slog.DiscardHandler))
+	if tel.jwks.ttl != config.DefaultJWKSTTL {
+		t.Errorf("jwks ttl = %s, want the default", tel.jwks.ttl)
+	}
+
+	status := func() (int, time.Duration) {
+		_, err := tel.ExchangeCode(context.Background(), url.Values{})
+		var oe *utilities.OAuthError
+		if !errors.As(err, &oe) {
+			t.Fatalf("error = %v, want *utilities.OAuthError", err)
+		}
+		return oe.Status, oe.RetryAfter
+	}
+	if got, _ := status(); got != http.StatusGatewayTimeout {
+		t.Errorf("slow telco: status = %d, want 504 after the configured timeout", got)
+	}
+	if got, _ := status(); got != http.StatusBadGateway {
+		t.Errorf("second failure: status = %d, want 502", got)
+	}
+	if got, retry := status(); got != http.StatusServiceUnavailable || retry != 7*time.Second {
+		t.Errorf("breaker: status = %d, Retry-After %s; want 503 after 2 failures with the configured 7s", got, retry)
+	}
+
+	limited := New(config.Telco{BaseURL: srv.URL, Resilience: config.Resilience{RateLimit: 0.001, RateBurst: 1}}, nil, slog.New(slog.DiscardHandler))
+	limited.ExchangeCode(context.Background(), url.Values{})
+	_, err := limited.ExchangeCode(context.Background(), url.Values{})
+	var oe *utilities.OAuthError
+	if !errors.As(err, &oe) || oe.Status != http.StatusGatewayTimeout {
+		t.Errorf("rate limited: error = %v, want 504 once the wait would pass the deadline", err)
+	}
+}
+
+func TestExchangeCode_BreakerClassification(t *testing.T) {
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "", status)
+	}))
+	defer srv.Close()
+
+	exchange := func(tel *TelcoClient) int {
+		_, err := tel.ExchangeCode(context.Background(), url.Values{})
+		var oe *utilities.OAuthError
+		if !errors.As(err, &oe) {
+			t.Fatalf("error = %v, want *utilities.OAuthError", err)
+		}
+		return oe.Status
+	}
+
+	tel := New(config.Telco{BaseURL: srv.URL}, nil, slog.New(slog.DiscardHandler))
+	for range 5 {
+		if got := exchange(tel); got != http.StatusBadRequest {
+			t.Fatalf("invalid_grant: status = %d, want 400 with the breaker still closed", got)
+		}
+	}
+
+	status = http.StatusTooManyRequests
+	strict := New(config.Telco{BaseURL: srv.URL, Resilience: config.Resilience{
+		Breaker: config.Breaker{ConsecutiveFailures: 1, FailureStatuses: []string{"429", "5xx"}},
+	}}, nil, slog.New(slog.DiscardHandler))
+	exchange(strict)
+	if _, err := strict.ExchangeCode(context.Background(), url.Values{}); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Errorf("429 with failure_statuses [429]: error = %v, want the breaker open", err)
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/Forty-SixNTwo/sim-auth-token-broker/libs/logs v0.0.0
 	github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities v0.0.0
 	github.com/go-jose/go-jose/v4 v4.1.0
+	golang.org/x/crypto v0.37.0
 )
 
 require (
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/authz"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/router"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/service"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/graceful"
@@ -20,24 +29,152 @@ func main() {
 		log.Fatalf("config error: %v", err)
 	}
 
-	if err := jwt.InitHS256([]byte(cfg.SigningKey)); err != nil {
+	if err := initSigning(cfg); err != nil {
 		logs.Fatal(logger, "jwt init failed", "error", err)
 	}
 
+	var clientCAs *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		clientCAs, err = graceful.LoadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			logs.Fatal(logger, "client CA bundle init failed", "error", err)
+		}
+	}
+
+	authn, err := auth.NewAuthenticator(cfg.Clients, clientCAs)
+	if err != nil {
+		logs.Fatal(logger, "client registry init failed", "error", err)
+	}
+
+	var store refresh.Store = refresh.NewMemoryStore()
+	if cfg.RefreshPath != "" {
+		store, err = refresh.NewFileStore(cfg.RefreshPath)
+		if err != nil {
+			logs.Fatal(logger, "refresh store init failed", "error", err)
+		}
+	}
+
+	telcos := clients.NewRegistry(cfg.PrefixMap, logger)
+	telcos.Start()
+	reloader := router.NewReloader(cfg.PrefixMapPath, telcos, logger)
+	reloader.Start()
+
 	mux := http.NewServeMux()
-	handler := service.NewTokenHandler(cfg, logger)
-	mux.Handle("/token",
+	refreshes := refresh.NewManager(store, cfg.RefreshTTL)
+	revoked := jwt.NewRevocationList()
+	codes := authz.NewStore[authz.Code](authz.CodeTTL)
+	handler := service.NewTokenHandler(cfg, telcos, authn, refreshes, codes, revoked, logger)
+	authorize := service.NewAuthorizeHandler(cfg, telcos, authn, codes, logger)
+	revoke := service.NewRevokeHandler(cfg, authn, refreshes, revoked, logger)
+	introspect := service.NewIntrospectHandler(cfg, authn, revoked, logger)
+	userinfo := service.NewUserinfoHandler(cfg, telcos, revoked, logger)
+	discovery := service.NewDiscoveryHandler(cfg)
+	mux.Handle(service.TokenPath,
 		logs.LoggingMiddleware(logger)(
 			http.HandlerFunc(handler.Handle),
 		),
 	)
+	mux.Handle(service.AuthorizePath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(authorize.Handle),
+		),
+	)
+	mux.Handle(service.CallbackPath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(authorize.Callback),
+		),
+	)
+	mux.Handle(service.RevokePath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(revoke.Handle),
+		),
+	)
+	mux.Handle(service.IntrospectPath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(introspect.Handle),
+		),
+	)
+	mux.Handle(service.UserinfoPath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(userinfo.Handle),
+		),
+	)
+	mux.Handle(service.JWKSPath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(jwt.JWKsHandler),
+		),
+	)
+	mux.Handle(service.OAuthMetadataPath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(discovery.Handle),
+		),
+	)
+	mux.Handle(service.OIDCMetadataPath,
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(discovery.Handle),
+		),
+	)
+	stopAdmin := func() {}
+	if cfg.AdminAddr != "" {
+		admin := http.NewServeMux()
+		admin.Handle(service.StatsPath,
+			logs.LoggingMiddleware(logger)(
+				http.HandlerFunc(service.NewStatsHandler(telcos).Handle),
+			),
+		)
+		stopAdmin = startAdmin(cfg.AdminAddr, admin, logger)
+	}
 
 	srv := &http.Server{
 		Addr:    cfg.ListenAddr,
 		Handler: mux,
 	}
+	if cfg.TLSCertFile != "" {
+		srv.TLSConfig, err = graceful.ServerTLS(cfg.TLSCertFile, cfg.TLSKeyFile, clientCAs)
+		if err != nil {
+			logs.Fatal(logger, "tls init failed", "error", err)
+		}
+	}
 
-	if err := graceful.StartServer(srv, 5*time.Second, logger); err != nil {
+	if err := graceful.StartServer(srv, 5*time.Second, logger, stopAdmin, reloader.Stop, telcos.Close); err != nil {
 		logs.Fatal(logger, "server failure", "error", err)
 	}
 }
+
+// startAdmin serves operator endpoints on their own listener, which should only be reachable from inside
+// the deployment. It returns a function that shuts the listener down.
+func startAdmin(addr string, handler http.Handler, logger *slog.Logger) func() {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		logger.Info("Admin server listening", "address", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("admin server failure", "error", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
+
+func initSigning(cfg *config.BrokerConfig) error {
+	if len(cfg.SigningKeys) == 0 {
+		return jwt.InitHS256([]byte(cfg.SigningKey))
+	}
+	keys := make([]jwt.SigningKey, 0, len(cfg.SigningKeys))
+	for _, k := range cfg.SigningKeys {
+		priv, err := jwt.ParsePrivateKeyPEM(k.PEM)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", k.KeyID, err)
+		}
+		keys = append(keys, jwt.SigningKey{
+			KeyID:      k.KeyID,
+			Algorithm:  k.Algorithm,
+			Key:        priv,
+			ActiveFrom: k.ActiveFrom,
+			RetireAt:   k.RetireAt,
+		})
+	}
+	return jwt.InitKeyring(keys)
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"encoding/json"
+	"maps"
+)
+
+type IntrospectionResponse struct {
+	Active     bool           `json:"active"`
+	ClientID   string         `json:"client_id,omitempty"`
+	TokenType  string         `json:"token_type,omitempty"`
+	Issuer     string         `json:"iss,omitempty"`
+	Subject    string         `json:"sub,omitempty"`
+	Audience   []string       `json:"aud,omitempty"`
+	ExpiresAt  int64          `json:"exp,omitempty"`
+	IssuedAt   int64          `json:"iat,omitempty"`
+	ID         string         `json:"jti,omitempty"`
+	AuthMethod string         `json:"auth_method,omitempty"`
+	Claims     map[string]any `json:"-"`
+}
+
+// MarshalJSON writes Claims next to the standard members; the standard members win on conflict.
+func (r IntrospectionResponse) MarshalJSON() ([]byte, error) {
+	type plain IntrospectionResponse
+	std, err := json.Marshal(plain(r))
+	if err != nil || len(r.Claims) == 0 {
+		return std, err
+	}
+	out := maps.Clone(r.Claims)
+	var fields map[string]any
+	if err := json.Unmarshal(std, &fields); err != nil {
+		return nil, err
+	}
+	maps.Copy(out, fields)
+	return json.Marshal(out)
+}
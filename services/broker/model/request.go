@@ -1,6 +1,10 @@
 package model
 
-import "net/http"
+import (
+	"fmt"
+	"mime"
+	"net/http"
+)
 
 type TokenRequest struct {
 	GrantType    string
@@ -8,9 +12,20 @@ type TokenRequest struct {
 	Phone        string
 	RedirectURI  string
 	CodeVerifier string
+	RefreshToken string
+	Scope        string
+
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Audience           []string
+	Resource           []string
 }
 
 func Parse(r *http.Request) (TokenRequest, error) {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+		return TokenRequest{}, fmt.Errorf("expected a form-encoded body, got %q", ct)
+	}
 	if err := r.ParseForm(); err != nil {
 		return TokenRequest{}, err
 	}
@@ -20,5 +35,13 @@ func Parse(r *http.Request) (TokenRequest, error) {
 		Phone:        r.PostFormValue("phone"),
 		RedirectURI:  r.PostFormValue("redirect_uri"),
 		CodeVerifier: r.PostFormValue("code_verifier"),
+		RefreshToken: r.PostFormValue("refresh_token"),
+		Scope:        r.PostFormValue("scope"),
+
+		SubjectToken:       r.PostFormValue("subject_token"),
+		SubjectTokenType:   r.PostFormValue("subject_token_type"),
+		RequestedTokenType: r.PostFormValue("requested_token_type"),
+		Audience:           r.PostForm["audience"],
+		Resource:           r.PostForm["resource"],
 	}, nil
 }
@@ -0,0 +1,123 @@
+package refresh
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const claimPhoneNumber = "phone_number"
+
+var ErrInvalidToken = errors.New("refresh token is invalid or expired")
+
+type Manager struct {
+	store Store
+	ttl   time.Duration
+}
+
+func NewManager(store Store, ttl time.Duration) *Manager {
+	return &Manager{store: store, ttl: ttl}
+}
+
+// Issue starts a new token family for t and returns the opaque refresh token.
+func (m *Manager) Issue(t Token) (string, error) {
+	value, hash := newValue()
+	t.Hash = hash
+	t.FamilyID = newFamilyID()
+	t.ExpiresAt = time.Now().Add(m.ttl)
+	if !t.NotAfter.IsZero() && t.NotAfter.Before(t.ExpiresAt) {
+		t.ExpiresAt = t.NotAfter
+	}
+	t.RotatedAt = time.Time{}
+	if err := m.store.Create(t); err != nil {
+		return "", fmt.Errorf("store refresh token: %w", err)
+	}
+	return value, nil
+}
+
+// Redeem exchanges value for its successor in the same family. A token bound to a DPoP key is only
+// redeemed with a proof for that key (jkt). Presenting a token that was already rotated revokes the
+// whole family and returns ErrReused.
+func (m *Manager) Redeem(value, clientID, jkt string) (Token, string, error) {
+	t, err := m.store.Get(hashValue(value))
+	if errors.Is(err, ErrNotFound) {
+		return Token{}, "", ErrInvalidToken
+	}
+	if err != nil {
+		return Token{}, "", err
+	}
+	if t.ClientID != clientID || t.JKT != jkt && t.JKT != "" || !time.Now().Before(t.ExpiresAt) {
+		return Token{}, "", ErrInvalidToken
+	}
+	if t.Rotated() {
+		return t, "", m.revoke(t)
+	}
+
+	next := t
+	nextValue, nextHash := newValue()
+	next.Hash = nextHash
+	err = m.store.Rotate(t.Hash, next)
+	if errors.Is(err, ErrReused) {
+		return t, "", m.revoke(t)
+	}
+	if err != nil {
+		return Token{}, "", fmt.Errorf("rotate refresh token: %w", err)
+	}
+	return next, nextValue, nil
+}
+
+// Revoke revokes the family of value if it was issued to clientID. Unknown tokens are ignored.
+func (m *Manager) Revoke(value, clientID string) (bool, error) {
+	t, err := m.store.Get(hashValue(value))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if t.ClientID != clientID {
+		return false, nil
+	}
+	return true, m.store.RevokeFamily(t.FamilyID)
+}
+
+// RevokeSubject revokes every family for subject and every family for phone; either may be empty. Phones
+// are matched on the grant, not its claims, which the client's claim filter may have dropped; the claim is
+// only consulted for families stored before grants recorded the phone.
+func (m *Manager) RevokeSubject(subject, phone string) error {
+	return m.store.RevokeMatching(func(t Token) bool {
+		if subject != "" && t.Subject == subject {
+			return true
+		}
+		return phone != "" && (t.Phone == phone || t.Phone == "" && t.Claims[claimPhoneNumber] == phone)
+	})
+}
+
+func (m *Manager) revoke(t Token) error {
+	if err := m.store.RevokeFamily(t.FamilyID); err != nil {
+		return fmt.Errorf("%w; revoking family: %v", ErrReused, err)
+	}
+	return ErrReused
+}
+
+func newValue() (value, hash string) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	value = base64.RawURLEncoding.EncodeToString(b)
+	return value, hashValue(value)
+}
+
+func newFamilyID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
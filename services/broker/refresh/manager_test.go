@@ -0,0 +1,73 @@
+package refresh
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManager_RotateAndReuse(t *testing.T) {
+	m := NewManager(NewMemoryStore(), time.Hour)
+	first, err := m.Issue(Token{ClientID: "demo-app", Subject: "sub-1", AccessTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if _, _, err := m.Redeem(first, "other-app", ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem by another client error = %v, want ErrInvalidToken", err)
+	}
+
+	grant, second, err := m.Redeem(first, "demo-app", "")
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if second == "" || second == first {
+		t.Fatalf("Redeem returned %q, want a new token", second)
+	}
+	if grant.Subject != "sub-1" || grant.AccessTTL != time.Minute {
+		t.Errorf("Redeem grant = %+v, want the original subject and TTL", grant)
+	}
+
+	if _, _, err := m.Redeem(first, "demo-app", ""); !errors.Is(err, ErrReused) {
+		t.Fatalf("reusing rotated token error = %v, want ErrReused", err)
+	}
+	if _, _, err := m.Redeem(second, "demo-app", ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("successor after reuse error = %v, want ErrInvalidToken (family revoked)", err)
+	}
+}
+
+func TestManager_Expired(t *testing.T) {
+	m := NewManager(NewMemoryStore(), -time.Second)
+	value, err := m.Issue(Token{ClientID: "demo-app"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, _, err := m.Redeem(value, "demo-app", ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem expired error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestFileStore_SurvivesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refresh.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	value, err := NewManager(store, time.Hour).Issue(Token{ClientID: "demo-app", Subject: "sub-1"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	grant, _, err := NewManager(reloaded, time.Hour).Redeem(value, "demo-app", "")
+	if err != nil {
+		t.Fatalf("Redeem after reload: %v", err)
+	}
+	if grant.Subject != "sub-1" {
+		t.Errorf("subject = %q, want sub-1", grant.Subject)
+	}
+}
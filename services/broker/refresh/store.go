@@ -0,0 +1,244 @@
+package refresh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+var (
+	ErrNotFound = errors.New("refresh token not found")
+	ErrReused   = errors.New("refresh token was already rotated")
+)
+
+type Token struct {
+	Hash      string         `json:"hash"`
+	FamilyID  string         `json:"family_id"`
+	ClientID  string         `json:"client_id"`
+	Subject   string         `json:"sub"`
+	Phone     string         `json:"phone,omitempty"`
+	Audience  []string       `json:"aud"`
+	Claims    map[string]any `json:"claims,omitempty"`
+	Scope     string         `json:"scope,omitempty"`
+	IDClaims  map[string]any `json:"id_claims,omitempty"`
+	JKT       string         `json:"jkt,omitempty"`
+	AccessTTL time.Duration  `json:"access_ttl"`
+	NotAfter  time.Time      `json:"not_after,omitzero"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	RotatedAt time.Time      `json:"rotated_at,omitzero"`
+}
+
+func (t Token) Rotated() bool {
+	return !t.RotatedAt.IsZero()
+}
+
+// Store persists refresh tokens by hash. Rotate must be atomic: it marks old as rotated and saves next,
+// or returns ErrReused if old had already been rotated.
+type Store interface {
+	Create(t Token) error
+	Get(hash string) (Token, error)
+	Rotate(oldHash string, next Token) error
+	RevokeFamily(familyID string) error
+	RevokeMatching(match func(Token) bool) error
+}
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	tokens    map[string]Token
+	families  map[string][]string
+	nextSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:   make(map[string]Token),
+		families: make(map[string][]string),
+	}
+}
+
+func (s *MemoryStore) Create(t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.put(t)
+	return nil
+}
+
+func (s *MemoryStore) Get(hash string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hash]
+	if !ok {
+		return Token{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *MemoryStore) Rotate(oldHash string, next Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.tokens[oldHash]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.Rotated() {
+		return ErrReused
+	}
+	old.RotatedAt = time.Now()
+	s.tokens[oldHash] = old
+	s.put(next)
+	return nil
+}
+
+func (s *MemoryStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hash := range s.families[familyID] {
+		delete(s.tokens, hash)
+	}
+	delete(s.families, familyID)
+	return nil
+}
+
+func (s *MemoryStore) RevokeMatching(match func(Token) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for family, hashes := range s.families {
+		t, ok := s.tokens[hashes[0]]
+		if !ok || !match(t) {
+			continue
+		}
+		for _, hash := range hashes {
+			delete(s.tokens, hash)
+		}
+		delete(s.families, family)
+	}
+	return nil
+}
+
+func (s *MemoryStore) put(t Token) {
+	s.tokens[t.Hash] = t
+	s.families[t.FamilyID] = append(s.families[t.FamilyID], t.Hash)
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for family, hashes := range s.families {
+		if t, ok := s.tokens[hashes[0]]; ok && now.Before(t.ExpiresAt) {
+			continue
+		}
+		for _, hash := range hashes {
+			delete(s.tokens, hash)
+		}
+		delete(s.families, family)
+	}
+	s.nextSweep = now.Add(sweepInterval)
+}
+
+func (s *MemoryStore) snapshot() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		out = append(out, t)
+	}
+	return out
+}
+
+// FileStore keeps tokens in memory and rewrites a JSON file after every change, so state survives restarts
+// of a single broker instance.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	mem  *MemoryStore
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, mem: NewMemoryStore()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading refresh store: %w", err)
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parsing refresh store: %w", err)
+	}
+	for _, t := range tokens {
+		s.mem.put(t)
+	}
+	return s, nil
+}
+
+func (s *FileStore) Create(t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Create(t); err != nil {
+		return err
+	}
+	return s.persist()
+}
+
+func (s *FileStore) Get(hash string) (Token, error) {
+	return s.mem.Get(hash)
+}
+
+func (s *FileStore) Rotate(oldHash string, next Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Rotate(oldHash, next); err != nil {
+		return err
+	}
+	return s.persist()
+}
+
+func (s *FileStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.RevokeFamily(familyID); err != nil {
+		return err
+	}
+	return s.persist()
+}
+
+func (s *FileStore) RevokeMatching(match func(Token) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.RevokeMatching(match); err != nil {
+		return err
+	}
+	return s.persist()
+}
+
+func (s *FileStore) persist() error {
+	data, err := json.Marshal(s.mem.snapshot())
+	if err != nil {
+		return fmt.Errorf("encoding refresh store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".refresh-*")
+	if err != nil {
+		return fmt.Errorf("writing refresh store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing refresh store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing refresh store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing refresh store: %w", err)
+	}
+	return nil
+}
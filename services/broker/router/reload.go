@@ -0,0 +1,131 @@
+package router
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+)
+
+const pollInterval = 5 * time.Second
+
+// Target receives routers built from reloaded prefix maps.
+type Target interface {
+	Routes() *Router
+	Update(*Router)
+}
+
+// Reloader rebuilds the router when the prefix map file changes or the process gets SIGHUP. A file that
+// fails to load is rejected and the current router stays in place.
+type Reloader struct {
+	path     string
+	target   Target
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+
+	started  atomic.Bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewReloader(path string, target Target, logger *slog.Logger) *Reloader {
+	r := &Reloader{
+		path:     path,
+		target:   target,
+		interval: pollInterval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	r.modTime, r.size = r.stat()
+	return r
+}
+
+// Reload loads the prefix map and, if it is valid, hands the new router to the target.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.modTime, r.size = r.stat()
+
+	prefixes, err := config.LoadPrefixMap(r.path)
+	if err != nil {
+		r.logger.Error("prefix map reload rejected, keeping current routes", "path", r.path, "error", err)
+		return err
+	}
+	next := New(prefixes)
+	changes := Diff(r.target.Routes(), next)
+	if changes.Empty() {
+		r.logger.Info("prefix map reloaded without changes", "path", r.path)
+		return nil
+	}
+	r.target.Update(next)
+	r.logger.Info("prefix map reloaded",
+		"path", r.path,
+		"added", changes.Added,
+		"removed", changes.Removed,
+		"changed", changes.Changed,
+	)
+	return nil
+}
+
+func (r *Reloader) Start() {
+	if r.started.CompareAndSwap(false, true) {
+		go r.run()
+	}
+}
+
+func (r *Reloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	if r.started.Load() {
+		<-r.done
+	}
+}
+
+func (r *Reloader) run() {
+	defer close(r.done)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-hup:
+			r.logger.Info("SIGHUP received, reloading prefix map", "path", r.path)
+			r.Reload()
+		case <-ticker.C:
+			if r.changed() {
+				r.Reload()
+			}
+		}
+	}
+}
+
+func (r *Reloader) changed() bool {
+	modTime, size := r.stat()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !modTime.Equal(r.modTime) || size != r.size
+}
+
+func (r *Reloader) stat() (time.Time, int64) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
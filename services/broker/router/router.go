@@ -0,0 +1,99 @@
+package router
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+)
+
+// Router maps dial prefixes to telcos with a digit trie. It is immutable once built, so it can be
+// shared between requests and replaced wholesale on reload.
+type Router struct {
+	root     *node
+	prefixes map[string]config.Telco
+}
+
+type node struct {
+	children map[byte]*node
+	telco    *config.Telco
+}
+
+func New(prefixMap map[string]config.Telco) *Router {
+	r := &Router{root: &node{}, prefixes: maps.Clone(prefixMap)}
+	for prefix, telco := range r.prefixes {
+		n := r.root
+		for i := 0; i < len(prefix); i++ {
+			if n.children == nil {
+				n.children = make(map[byte]*node)
+			}
+			next, ok := n.children[prefix[i]]
+			if !ok {
+				next = &node{}
+				n.children[prefix[i]] = next
+			}
+			n = next
+		}
+		n.telco = &telco
+	}
+	return r
+}
+
+// Match returns the telco with the longest prefix of phone, which may carry a leading "+".
+func (r *Router) Match(phone string) (config.Telco, error) {
+	pn := strings.TrimPrefix(strings.TrimSpace(phone), "+")
+	var found *config.Telco
+	n := r.root
+	for i := 0; i < len(pn) && n != nil; i++ {
+		n = n.children[pn[i]]
+		if n != nil && n.telco != nil {
+			found = n.telco
+		}
+	}
+	if found == nil {
+		return config.Telco{}, fmt.Errorf("no prefix match for %q", pn)
+	}
+	return *found, nil
+}
+
+// Telcos returns the telco of every prefix, in prefix order; telcos serving several prefixes repeat.
+func (r *Router) Telcos() []config.Telco {
+	out := make([]config.Telco, 0, len(r.prefixes))
+	for _, prefix := range slices.Sorted(maps.Keys(r.prefixes)) {
+		out = append(out, r.prefixes[prefix])
+	}
+	return out
+}
+
+// Changes lists the prefixes added, removed and pointing at a different telco between two routers.
+type Changes struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+func Diff(old, new *Router) Changes {
+	var c Changes
+	for _, prefix := range slices.Sorted(maps.Keys(new.prefixes)) {
+		prev, ok := old.prefixes[prefix]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, prefix)
+		case !reflect.DeepEqual(prev, new.prefixes[prefix]):
+			c.Changed = append(c.Changed, prefix)
+		}
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(old.prefixes)) {
+		if _, ok := new.prefixes[prefix]; !ok {
+			c.Removed = append(c.Removed, prefix)
+		}
+	}
+	return c
+}
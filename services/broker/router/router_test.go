@@ -0,0 +1,115 @@
+package router
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+)
+
+func TestMatch(t *testing.T) {
+	r := New(map[string]config.Telco{
+		"97205":  {BaseURL: "orange"},
+		"972050": {BaseURL: "vodafone"},
+		"4477":   {BaseURL: "vf-uk"},
+	})
+
+	cases := []struct {
+		phone   string
+		wantURL string
+		wantErr bool
+	}{
+		{"+97205012345", "vodafone", false},
+		{"+97205123456", "orange", false},
+		{"4477123456", "vf-uk", false},
+		{"12345", "", true},
+		{"+9720", "", true},
+	}
+
+	for _, c := range cases {
+		telco, err := r.Match(c.phone)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Match(%q) error = %v, wantErr %v", c.phone, err, c.wantErr)
+			continue
+		}
+		if err == nil && telco.BaseURL != c.wantURL {
+			t.Errorf("Match(%q).BaseURL = %q, want %q", c.phone, telco.BaseURL, c.wantURL)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := New(map[string]config.Telco{
+		"97254": {BaseURL: "partner"},
+		"97252": {BaseURL: "cellcom"},
+		"97250": {BaseURL: "pelephone"},
+	})
+	next := New(map[string]config.Telco{
+		"97254": {BaseURL: "partner"},
+		"97252": {BaseURL: "cellcom-v2"},
+		"97258": {BaseURL: "golan"},
+	})
+	c := Diff(old, next)
+	if !slices.Equal(c.Added, []string{"97258"}) || !slices.Equal(c.Removed, []string{"97250"}) || !slices.Equal(c.Changed, []string{"97252"}) {
+		t.Errorf("Diff = %+v", c)
+	}
+	if !Diff(old, old).Empty() {
+		t.Error("Diff of a router with itself is not empty")
+	}
+}
+
+type target struct{ routes *Router }
+
+func (t *target) Routes() *Router  { return t.routes }
+func (t *target) Update(r *Router) { t.routes = r }
+
+func TestReloader(t *testing.T) {
+	t.Setenv("TEST_TELCO_ID", "id")
+	t.Setenv("TEST_TELCO_SECRET", "secret")
+	path := filepath.Join(t.TempDir(), "prefix_map.yaml")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write prefix map: %v", err)
+		}
+	}
+	write(`prefixes:
+  "97254": {base_url: "http://partner", client_id: TEST_TELCO_ID, client_secret: TEST_TELCO_SECRET}
+`)
+	prefixes, err := config.LoadPrefixMap(path)
+	if err != nil {
+		t.Fatalf("LoadPrefixMap: %v", err)
+	}
+	tgt := &target{routes: New(prefixes)}
+	r := NewReloader(path, tgt, slog.New(slog.DiscardHandler))
+
+	write(`prefixes:
+  "97254": {base_url: "http://partner", client_id: TEST_TELCO_ID, client_secret: TEST_TELCO_SECRET}
+  "97252": {base_url: "http://cellcom", client_id: TEST_TELCO_ID, client_secret: TEST_TELCO_SECRET}
+`)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if telco, err := tgt.routes.Match("+972521234567"); err != nil || telco.BaseURL != "http://cellcom" {
+		t.Fatalf("new prefix not routed: %+v, %v", telco, err)
+	}
+
+	current := tgt.routes
+	for _, bad := range []string{
+		"prefixes: [not, a, map]",
+		"prefixes:\n  \"97x\": {base_url: \"http://bad\", client_id: TEST_TELCO_ID, client_secret: TEST_TELCO_SECRET}\n",
+		"prefixes:\n  \"97253\": {base_url: \"http://bad\", client_id: MISSING_ENV, client_secret: TEST_TELCO_SECRET}\n",
+		"",
+	} {
+		write(bad)
+		if err := r.Reload(); err == nil {
+			t.Errorf("Reload accepted %q", bad)
+		}
+		if tgt.routes != current {
+			t.Errorf("Reload of %q replaced the router", bad)
+		}
+	}
+}
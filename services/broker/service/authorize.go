@@ -0,0 +1,216 @@
+package service
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/authz"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/utils"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/logs"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+type AuthorizeHandler struct {
+	cfg      *config.BrokerConfig
+	upstream upstream
+	clients  *auth.Authenticator
+	pending  *authz.Store[authz.Pending]
+	codes    *authz.Store[authz.Code]
+	errs     *utilities.ErrorReporter
+	logger   *slog.Logger
+}
+
+func NewAuthorizeHandler(cfg *config.BrokerConfig, telcos *clients.Registry, clients *auth.Authenticator, codes *authz.Store[authz.Code], logger *slog.Logger) *AuthorizeHandler {
+	return &AuthorizeHandler{
+		cfg:      cfg,
+		upstream: upstream{cfg: cfg, telcos: telcos, logger: logger},
+		clients:  clients,
+		pending:  authz.NewStore[authz.Pending](authz.PendingTTL),
+		codes:    codes,
+		errs:     utilities.NewErrorReporter(logger, cfg.DebugErrors),
+		logger:   logger,
+	}
+}
+
+// Handle starts a SIM authorization: it validates the client's request, picks the telco for the phone
+// number and sends the user agent to that telco with the broker's own PKCE challenge.
+func (h *AuthorizeHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "authorization requests must use GET", http.StatusMethodNotAllowed))
+		return
+	}
+	q := r.URL.Query()
+
+	client, ok := h.clients.Client(q.Get("client_id"))
+	if !ok {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "unknown client_id", http.StatusBadRequest))
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if len(client.RedirectURIs) == 0 || !client.AllowsRedirect(redirectURI) {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "redirect_uri is not registered for this client", http.StatusBadRequest))
+		return
+	}
+
+	state := q.Get("state")
+	if q.Get("response_type") != "code" {
+		h.redirectError(w, r, redirectURI, state, utilities.UnsupportedResponseType, "only response_type=code is supported")
+		return
+	}
+	if !client.AllowsGrant(config.GrantAuthorizationCode) {
+		h.redirectError(w, r, redirectURI, state, utilities.UnauthorizedClient, "client may not use the authorization code grant")
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if challenge == "" || q.Get("code_challenge_method") != authz.MethodS256 {
+		h.redirectError(w, r, redirectURI, state, utilities.InvalidRequest, "PKCE with code_challenge_method=S256 is required")
+		return
+	}
+
+	raw := q.Get("phone")
+	if raw == "" {
+		raw = q.Get(jwt.LOGIN_HINT)
+	}
+	phone, err := utils.NormalizePhone(raw)
+	if err != nil {
+		h.redirectError(w, r, redirectURI, state, utilities.InvalidRequest, "phone or login_hint must be an E.164 phone number")
+		return
+	}
+	telcoCfg, err := h.upstream.telcos.Route(phone)
+	if err != nil {
+		h.redirectError(w, r, redirectURI, state, utilities.InvalidRequest, "no telco serves this phone number")
+		return
+	}
+	logs.Annotate(r, slog.Any("telco", telcoCfg))
+
+	verifier := authz.NewVerifier()
+	callback := publicBaseURL(h.cfg.Issuer, r) + CallbackPath
+	id := h.pending.Put(authz.Pending{
+		ClientID:      client.ClientID,
+		RedirectURI:   redirectURI,
+		State:         state,
+		CodeChallenge: challenge,
+		Scope:         q.Get(jwt.SCOPE),
+		Nonce:         q.Get(jwt.NONCE),
+		Phone:         phone,
+		Telco:         telcoCfg,
+		TelcoVerifier: verifier,
+		CallbackURL:   callback,
+	})
+
+	target, err := url.Parse(telcoCfg.AuthorizeURL)
+	if err != nil {
+		h.redirectError(w, r, redirectURI, state, utilities.ServerError, "telco authorize_url is invalid")
+		return
+	}
+	params := target.Query()
+	params.Set("response_type", "code")
+	params.Set("client_id", telcoCfg.ClientID)
+	params.Set("redirect_uri", callback)
+	params.Set("state", id)
+	params.Set("code_challenge", authz.S256(verifier))
+	params.Set("code_challenge_method", authz.MethodS256)
+	params.Set(jwt.LOGIN_HINT, phone)
+	if jwt.HasScope(q.Get(jwt.SCOPE), jwt.SCOPE_OPENID) {
+		params.Set(jwt.SCOPE, jwt.SCOPE_OPENID)
+	}
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Callback receives the telco's authorization response, redeems the telco code and hands the client a
+// broker code bound to its original request.
+func (h *AuthorizeHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "authorization callbacks must use GET", http.StatusMethodNotAllowed))
+		return
+	}
+	q := r.URL.Query()
+
+	p, ok := h.pending.Take(q.Get("state"))
+	if !ok {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "unknown or expired state", http.StatusBadRequest))
+		return
+	}
+	logs.Annotate(r, slog.Any("telco", p.Telco))
+	if e := q.Get("error"); e != "" {
+		h.logger.Warn("telco denied authorization", "telco", p.Telco, "error", e)
+		h.redirectError(w, r, p.RedirectURI, p.State, utilities.AccessDenied, "the telco did not authenticate the subscriber")
+		return
+	}
+	client, ok := h.clients.Client(p.ClientID)
+	if !ok {
+		h.redirectError(w, r, p.RedirectURI, p.State, utilities.UnauthorizedClient, "client is no longer registered")
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {config.GrantAuthorizationCode},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {p.CallbackURL},
+		"code_verifier": {p.TelcoVerifier},
+		"scope":         {p.Scope},
+	}
+	grant, notAfter, gerr := h.upstream.exchange(r.Context(), client, p.Telco, p.Phone, form)
+	if gerr != nil {
+		h.logger.Warn("telco code exchange failed",
+			"request_id", utilities.RequestID(r.Context()),
+			"telco", p.Telco,
+			"client_id", p.ClientID,
+			"error", gerr,
+		)
+		code := utilities.AccessDenied
+		switch {
+		case gerr.Status == http.StatusInternalServerError:
+			code = utilities.ServerError
+		case gerr.Status > http.StatusInternalServerError:
+			code = utilities.TemporarilyUnavailable
+		}
+		h.redirectError(w, r, p.RedirectURI, p.State, code, gerr.Description)
+		return
+	}
+
+	code := h.codes.Put(authz.Code{
+		ClientID:      p.ClientID,
+		RedirectURI:   p.RedirectURI,
+		CodeChallenge: p.CodeChallenge,
+		Nonce:         p.Nonce,
+		Grant:         grant,
+		NotAfter:      notAfter,
+	})
+	h.redirectWith(w, r, p.RedirectURI, url.Values{"code": {code}}, p.State)
+}
+
+// redirectError returns an authorization error to the client's redirect_uri. The user agent carries the
+// response, so the request ID is logged here for the error to be traced server-side.
+func (h *AuthorizeHandler) redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	h.logger.Info("authorization failed",
+		"request_id", utilities.RequestID(r.Context()),
+		"path", r.URL.Path,
+		"error_code", code,
+		"error", description,
+	)
+	h.redirectWith(w, r, redirectURI, url.Values{"error": {code}, "error_description": {description}}, state)
+}
+
+func (h *AuthorizeHandler) redirectWith(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values, state string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "redirect_uri is invalid", http.StatusBadRequest).Wrap(err))
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/authz"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+const clientRedirect = "https://app.example.com/cb"
+
+func TestAuthorizeFlow(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := jwt.InitKeyring([]jwt.SigningKey{{KeyID: "k1", Algorithm: string(jose.ES256), Key: priv}}); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", jwt.AuthorizeHandler("telco-client"))
+	mux.HandleFunc("/token", jwt.JWTsHandler("telco-client", "telco-secret", ""))
+	mux.HandleFunc("/.well-known/jwks.json", jwt.JWKsHandler)
+	telcoSrv := httptest.NewServer(mux)
+	defer telcoSrv.Close()
+
+	telco := config.Telco{
+		BaseURL:      telcoSrv.URL,
+		AuthorizeURL: telcoSrv.URL + "/authorize",
+		ClientID:     "telco-client",
+		ClientSecret: "telco-secret",
+		Leeway:       30 * time.Second,
+		SubjectClaim: jwt.PHONE_NUMBER,
+		Name:         "partner",
+		ACR:          config.DefaultACR,
+	}
+	cfg := &config.BrokerConfig{
+		Issuer:    "sim-broker",
+		TokenTTL:  15 * time.Minute,
+		PrefixMap: map[string]config.Telco{"97254": telco},
+	}
+	logger := slog.New(slog.DiscardHandler)
+	registry := clients.NewRegistry(cfg.PrefixMap, logger)
+	defer registry.Close()
+	authn := testClients(t, config.Client{ClientID: "demo-app", RedirectURIs: []string{clientRedirect}})
+	codes := authz.NewStore[authz.Code](authz.CodeTTL)
+	authorize := NewAuthorizeHandler(cfg, registry, authn, codes, logger)
+	token := NewTokenHandler(cfg, registry, authn, refresh.NewManager(refresh.NewMemoryStore(), time.Hour), codes, jwt.NewRevocationList(), logger)
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	verifier := authz.NewVerifier()
+
+	login := func() url.Values {
+		t.Helper()
+		w := httptest.NewRecorder()
+		authorize.Handle(w, httptest.NewRequest(http.MethodGet, AuthorizePath+"?"+url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"demo-app"},
+			"redirect_uri":          {clientRedirect},
+			"state":                 {"xyz"},
+			"code_challenge":        {authz.S256(verifier)},
+			"code_challenge_method": {"S256"},
+			"login_hint":            {"+972541234567"},
+			"scope":                 {"openid phone"},
+			"nonce":                 {"n-0S6"},
+		}.Encode(), nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("/authorize status = %d, body %s", w.Code, w.Body)
+		}
+
+		resp, err := noFollow.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("telco authorize: %v", err)
+		}
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || callback.Path != CallbackPath {
+			t.Fatalf("telco redirected to %q, want the broker callback", resp.Header.Get("Location"))
+		}
+
+		w = httptest.NewRecorder()
+		authorize.Callback(w, httptest.NewRequest(http.MethodGet, CallbackPath+"?"+callback.RawQuery, nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("callback status = %d, body %s", w.Code, w.Body)
+		}
+		back, _ := url.Parse(w.Header().Get("Location"))
+		if back.Scheme+"://"+back.Host+back.Path != clientRedirect {
+			t.Fatalf("callback redirected to %q, want the client", back)
+		}
+		return back.Query()
+	}
+	redeem := func(code, codeVerifier string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		token.Handle(w, postForm(TokenPath, "demo-app", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {clientRedirect},
+			"code_verifier": {codeVerifier},
+		}))
+		return w
+	}
+
+	first := login()
+	if first.Get("state") != "xyz" || first.Get("code") == "" {
+		t.Fatalf("client redirect params = %v, want code and state", first)
+	}
+	if w := redeem(first.Get("code"), authz.NewVerifier()); w.Code != http.StatusBadRequest {
+		t.Errorf("wrong code_verifier status = %d, want 400", w.Code)
+	}
+
+	w := redeem(login().Get("code"), verifier)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/token status = %d, body %s", w.Code, w.Body)
+	}
+	var resp model.TokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	claims, err := jwt.ValidateIssued(t.Context(), resp.AccessToken, jwt.Expected{Issuer: "sim-broker"})
+	if err != nil {
+		t.Fatalf("broker token invalid: %v", err)
+	}
+	if phone, _ := claims.Claim(jwt.PHONE_NUMBER); phone != "+972541234567" {
+		t.Errorf("phone_number = %q, want the authorized phone", phone)
+	}
+
+	if resp.IDToken == "" {
+		t.Fatal("no id_token for an openid request")
+	}
+	id, err := jwt.ValidateIssued(t.Context(), resp.IDToken, jwt.Expected{Issuer: "sim-broker", Audience: []string{"demo-app"}})
+	if err != nil {
+		t.Fatalf("id_token invalid: %v", err)
+	}
+	atHash, _ := jwt.AtHash(resp.AccessToken)
+	for name, want := range map[string]string{jwt.NONCE: "n-0S6", jwt.AT_HASH: atHash, jwt.PHONE_NUMBER: "+972541234567", claimACR: config.DefaultACR} {
+		if got, _ := id.Claim(name); got != want {
+			t.Errorf("id_token %s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{claimAuthTime, claimAMR, claimPhoneNumberVerified} {
+		if _, ok := id.Extra[name]; !ok {
+			t.Errorf("id_token is missing %s", name)
+		}
+	}
+}
+
+func TestAuthorizeHandler_ErrorsCarryErrorID(t *testing.T) {
+	cfg := &config.BrokerConfig{Issuer: "sim-broker"}
+	authn := testClients(t, config.Client{ClientID: "demo-app", RedirectURIs: []string{clientRedirect}})
+	h := NewAuthorizeHandler(cfg, nil, authn, authz.NewStore[authz.Code](authz.CodeTTL), slog.New(slog.DiscardHandler))
+
+	cases := []struct {
+		name   string
+		handle http.HandlerFunc
+		target string
+	}{
+		{"unknown client", h.Handle, AuthorizePath + "?client_id=nobody"},
+		{"unregistered redirect", h.Handle, AuthorizePath + "?client_id=demo-app&redirect_uri=https://evil.example.com/cb"},
+		{"unknown state", h.Callback, CallbackPath + "?state=missing"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.target, nil)
+		r = r.WithContext(context.WithValue(r.Context(), utilities.CtxRequestID{}, "req-42"))
+		w := httptest.NewRecorder()
+		c.handle(w, r)
+		var body map[string]any
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusBadRequest || body["error"] != utilities.InvalidRequest || body["error_id"] != "req-42" {
+			t.Errorf("%s: status %d body %v, want 400 invalid_request with error_id", c.name, w.Code, body)
+		}
+	}
+}
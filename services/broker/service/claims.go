@@ -0,0 +1,82 @@
+package service
+
+import (
+	"maps"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+)
+
+const (
+	claimPhoneNumber         = "phone_number"
+	claimPhoneNumberVerified = "phone_number_verified"
+	claimTelco               = "telco"
+	claimMCC                 = "mcc"
+	claimMNC                 = "mnc"
+	claimCountry             = "country"
+	claimAMR                 = "amr"
+	claimACR                 = "acr"
+	claimAuthTime            = "auth_time"
+	claimLegacyExtra         = "extra"
+	claimClientID            = "client_id"
+
+	amrSIM = "sim"
+)
+
+// idTokenClaims are the SIM claims every ID token carries, regardless of the client's claim filter.
+var idTokenClaims = []string{claimPhoneNumber, claimPhoneNumberVerified, claimAMR, claimACR, claimAuthTime}
+
+// verifiedClaims builds every SIM-auth claim the broker knows for a verified phone.
+func verifiedClaims(telco config.Telco, phone string, upstream *jwt.Payload) map[string]any {
+	all := map[string]any{
+		claimPhoneNumber:         phone,
+		claimPhoneNumberVerified: true,
+		claimAMR:                 []string{amrSIM},
+		claimACR:                 telco.ACR,
+		claimAuthTime:            authTime(upstream).Unix(),
+	}
+	for name, v := range map[string]string{
+		claimTelco:   telco.Name,
+		claimMCC:     telco.MCC,
+		claimMNC:     telco.MNC,
+		claimCountry: telco.Country,
+	} {
+		if v != "" {
+			all[name] = v
+		}
+	}
+	return all
+}
+
+// simClaims filters the verified claims to the set the client asked for.
+func simClaims(client *auth.Client, all map[string]any) map[string]any {
+	out := maps.Clone(all)
+	if len(client.Claims) > 0 {
+		out = pickClaims(all, client.Claims)
+	}
+	if client.LegacyExtra {
+		out[claimLegacyExtra] = map[string]any{"auth_method": amrSIM}
+	}
+	return out
+}
+
+// pickClaims copies the named claims that are present in all.
+func pickClaims(all map[string]any, names []string) map[string]any {
+	out := make(map[string]any, len(names))
+	for _, name := range names {
+		if v, ok := all[name]; ok {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// authTime is when the telco authenticated the subscriber, falling back to now if it did not say.
+func authTime(upstream *jwt.Payload) time.Time {
+	if v, ok := upstream.Extra[claimAuthTime].(float64); ok && v > 0 {
+		return time.Unix(int64(v), 0)
+	}
+	return time.Now()
+}
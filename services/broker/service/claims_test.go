@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+)
+
+func TestSimClaims(t *testing.T) {
+	telco := config.Telco{Name: "partner", MCC: "425", MNC: "01", Country: "IL", ACR: config.DefaultACR}
+	upstream := &jwt.Payload{Extra: map[string]any{"auth_time": float64(1700000000)}}
+
+	all := simClaims(&auth.Client{}, verifiedClaims(telco, "+972541234567", upstream))
+	for _, name := range []string{"phone_number", "phone_number_verified", "telco", "mcc", "mnc", "country", "amr", "acr", "auth_time"} {
+		if _, ok := all[name]; !ok {
+			t.Errorf("default claim set is missing %s", name)
+		}
+	}
+	if all["auth_time"] != int64(1700000000) {
+		t.Errorf("auth_time = %v, want the telco's value", all["auth_time"])
+	}
+	if _, ok := all["extra"]; ok {
+		t.Error("extra envelope present without legacy_extra")
+	}
+
+	narrow := simClaims(&auth.Client{Client: config.Client{
+		Claims:      []string{"phone_number"},
+		LegacyExtra: true,
+	}}, verifiedClaims(telco, "+972541234567", &jwt.Payload{}))
+	if len(narrow) != 2 || narrow["phone_number"] != "+972541234567" || narrow["extra"] == nil {
+		t.Errorf("filtered claims = %v, want phone_number and extra only", narrow)
+	}
+
+	id := pickClaims(verifiedClaims(telco, "+972541234567", upstream), idTokenClaims)
+	if len(id) != len(idTokenClaims) || id["acr"] != config.DefaultACR {
+		t.Errorf("ID token claims = %v, want %v", id, idTokenClaims)
+	}
+
+	if got := authTime(&jwt.Payload{}); time.Since(got) > time.Minute {
+		t.Errorf("authTime without upstream value = %v, want now", got)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+const (
+	AuthorizePath     = "/authorize"
+	CallbackPath      = "/authorize/callback"
+	TokenPath         = "/token"
+	RevokePath        = "/revoke"
+	IntrospectPath    = "/introspect"
+	UserinfoPath      = "/userinfo"
+	JWKSPath          = "/.well-known/jwks.json"
+	OAuthMetadataPath = "/.well-known/oauth-authorization-server"
+	OIDCMetadataPath  = "/.well-known/openid-configuration"
+)
+
+var authMethods = []string{
+	config.AuthClientSecretBasic,
+	config.AuthClientSecretPost,
+	config.AuthPrivateKeyJWT,
+}
+
+var mtlsAuthMethods = []string{config.AuthTLSClient, config.AuthSelfSignedTLS}
+
+var claimsSupported = []string{
+	"iss", "sub", "aud", "exp", "iat", claimAuthTime, jwt.NONCE, jwt.AT_HASH,
+	claimPhoneNumber, claimPhoneNumberVerified, claimAMR, claimACR,
+	claimTelco, claimMCC, claimMNC, claimCountry,
+}
+
+type DiscoveryHandler struct {
+	cfg *config.BrokerConfig
+}
+
+func NewDiscoveryHandler(cfg *config.BrokerConfig) *DiscoveryHandler {
+	return &DiscoveryHandler{cfg: cfg}
+}
+
+func (h *DiscoveryHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "discovery requests must use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, ok := configuredBaseURL(h.cfg.Issuer); ok {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	} else {
+		// Endpoints derived from the client-supplied Host must never be shared through a cache.
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	json.NewEncoder(w).Encode(h.Metadata(publicBaseURL(h.cfg.Issuer, r)))
+}
+
+func (h *DiscoveryHandler) Metadata(base string) model.ServerMetadata {
+	algs := jwt.SigningAlgorithms()
+	methods := authMethods
+	mtls := h.cfg.TLSCertFile != "" && h.cfg.ClientCAFile != ""
+	if mtls {
+		methods = slices.Concat(authMethods, mtlsAuthMethods)
+	}
+	return model.ServerMetadata{
+		Issuer:                            h.cfg.Issuer,
+		AuthorizationEndpoint:             base + AuthorizePath,
+		TokenEndpoint:                     base + TokenPath,
+		JWKSURI:                           base + JWKSPath,
+		RevocationEndpoint:                base + RevokePath,
+		IntrospectionEndpoint:             base + IntrospectPath,
+		UserinfoEndpoint:                  base + UserinfoPath,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{config.GrantAuthorizationCode, config.GrantRefreshToken, config.GrantTokenExchange},
+		TokenEndpointAuthMethodsSupported: methods,
+		TokenEndpointAuthSigningAlgs:      jwt.DefaultAlgorithms,
+		RevocationEndpointAuthMethods:     methods,
+		IntrospectionEndpointAuthMethods:  methods,
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		SubjectTypesSupported:             []string{"public"},
+		ScopesSupported:                   []string{jwt.SCOPE_OPENID, scopePhone, scopeTelco},
+		ClaimsSupported:                   claimsSupported,
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenSigningAlgValuesSupported:    algs,
+		DPoPSigningAlgValuesSupported:     jwt.DefaultAlgorithms,
+		TLSClientCertificateBoundTokens:   mtls,
+	}
+}
+
+// configuredBaseURL returns the issuer as the broker's base URL when BROKER_ISSUER_URL is an http(s) URL.
+func configuredBaseURL(issuer string) (string, bool) {
+	u, err := url.Parse(issuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(issuer, "/"), true
+}
+
+// publicBaseURL is the configured base URL or, without one, a URL built from the request's Host. The
+// fallback trusts a client-supplied header and is meant for local development only; production
+// configuration requires an https issuer.
+func publicBaseURL(issuer string, r *http.Request) string {
+	if base, ok := configuredBaseURL(issuer); ok {
+		return base
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
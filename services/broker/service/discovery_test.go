@@ -0,0 +1,55 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+)
+
+func TestDiscoveryHandler(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	fetch := func(issuer string) (*httptest.ResponseRecorder, model.ServerMetadata) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "http://evil.example"+OIDCMetadataPath, nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		NewDiscoveryHandler(&config.BrokerConfig{Issuer: issuer}).Handle(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body)
+		}
+		var meta model.ServerMetadata
+		if err := json.NewDecoder(w.Body).Decode(&meta); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return w, meta
+	}
+
+	w, meta := fetch("https://broker.example.com/")
+	if meta.Issuer != "https://broker.example.com/" || meta.TokenEndpoint != "https://broker.example.com"+TokenPath {
+		t.Errorf("issuer = %q, token_endpoint = %q, want them built from the configured issuer", meta.Issuer, meta.TokenEndpoint)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("configured issuer Cache-Control = %q, want public caching", cc)
+	}
+
+	w, meta = fetch(config.DefaultIssuer)
+	if meta.TokenEndpoint != "http://evil.example"+TokenPath {
+		t.Errorf("derived token_endpoint = %q, want the request host without the forwarded scheme", meta.TokenEndpoint)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("request-derived Cache-Control = %q, want no-store", cc)
+	}
+
+	w = httptest.NewRecorder()
+	NewDiscoveryHandler(&config.BrokerConfig{Issuer: config.DefaultIssuer}).Handle(w, httptest.NewRequest(http.MethodPost, OIDCMetadataPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+
+	jose "github.com/go-jose/go-jose/v4"
+	Jwt "github.com/go-jose/go-jose/v4/jwt"
+)
+
+func dpopProof(t *testing.T, key *ecdsa.PrivateKey, method, uri, accessToken string) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{EmbedJWK: true}).WithType(jwt.DPOP_TYPE))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	claims := map[string]any{"jti": jwt.NewID(), "htm": method, "htu": uri, "iat": time.Now().Unix()}
+	if accessToken != "" {
+		claims["ath"] = jwt.AccessTokenHash(accessToken)
+	}
+	proof, err := Jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("sign proof: %v", err)
+	}
+	return proof
+}
+
+func TestDPoPBoundTokens(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	const base = "https://broker.example"
+	cfg := &config.BrokerConfig{Issuer: base, TokenTTL: 15 * time.Minute}
+	logger := slog.New(slog.DiscardHandler)
+	refreshes := refresh.NewManager(refresh.NewMemoryStore(), time.Hour)
+	revoked := jwt.NewRevocationList()
+	token := NewTokenHandler(cfg, nil, testClients(t, config.Client{ClientID: "demo-app"}), refreshes, nil, revoked, logger)
+	telcos := clients.NewRegistry(nil, logger)
+	defer telcos.Close()
+	userinfoH := NewUserinfoHandler(cfg, telcos, revoked, logger)
+
+	redeem := func(rt, proof string) *httptest.ResponseRecorder {
+		r := postForm(TokenPath, "demo-app", url.Values{"grant_type": {config.GrantRefreshToken}, "refresh_token": {rt}})
+		if proof != "" {
+			r.Header.Set(jwt.DPOP, proof)
+		}
+		w := httptest.NewRecorder()
+		token.Handle(w, r)
+		return w
+	}
+
+	rt, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", Scope: "openid", AccessTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	w := redeem(rt, dpopProof(t, key, http.MethodPost, base+TokenPath, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var resp model.TokenResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.TokenType != jwt.DPOP {
+		t.Errorf("token_type = %q, want DPoP", resp.TokenType)
+	}
+	claims, err := jwt.ValidateIssued(t.Context(), resp.AccessToken, jwt.Expected{Issuer: base})
+	if err != nil {
+		t.Fatalf("ValidateIssued: %v", err)
+	}
+	if _, ok := jwt.ConfirmationJKT(claims); !ok {
+		t.Fatal("access token has no cnf.jkt")
+	}
+
+	call := func(scheme, proof string) int {
+		r := httptest.NewRequest(http.MethodGet, base+UserinfoPath, nil)
+		r.Header.Set("Authorization", scheme+" "+resp.AccessToken)
+		if proof != "" {
+			r.Header.Set(jwt.DPOP, proof)
+		}
+		w := httptest.NewRecorder()
+		userinfoH.Handle(w, r)
+		return w.Code
+	}
+	if code := call(jwt.DPOP, dpopProof(t, key, http.MethodGet, base+UserinfoPath, resp.AccessToken)); code != http.StatusOK {
+		t.Errorf("userinfo with proof status = %d, want 200", code)
+	}
+	if code := call("Bearer", ""); code != http.StatusUnauthorized {
+		t.Errorf("bound token as bearer status = %d, want 401", code)
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if code := call(jwt.DPOP, dpopProof(t, other, http.MethodGet, base+UserinfoPath, resp.AccessToken)); code != http.StatusUnauthorized {
+		t.Errorf("proof from another key status = %d, want 401", code)
+	}
+
+	if w := redeem(rt, "not-a-proof"); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed proof status = %d, want 400", w.Code)
+	}
+	bound, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", JKT: "bound-key", AccessTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if w := redeem(bound, dpopProof(t, key, http.MethodPost, base+TokenPath, "")); w.Code != http.StatusBadRequest {
+		t.Errorf("bound refresh token with another key status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/logs"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+const (
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+
+	claimActor = "act"
+)
+
+// tokenExchange implements RFC 8693: a client holding a broker access token (issued to it, or addressed to
+// it) trades it for a shorter-lived token for another audience it is allowed to call. The SIM claims carry
+// over and the exchanging client is recorded in "act".
+func (h *TokenHandler) tokenExchange(w http.ResponseWriter, r *http.Request, client *auth.Client, req model.TokenRequest, jkt string) {
+	if req.SubjectToken == "" || req.SubjectTokenType == "" {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "subject_token and subject_token_type are required", http.StatusBadRequest)
+		return
+	}
+	if req.SubjectTokenType != tokenTypeAccessToken && req.SubjectTokenType != tokenTypeJWT {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "subject_token_type must be an access token or JWT", http.StatusBadRequest)
+		return
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != tokenTypeAccessToken {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "only access tokens can be requested", http.StatusBadRequest)
+		return
+	}
+
+	subject, err := jwt.ValidateIssued(r.Context(), req.SubjectToken, jwt.Expected{Issuer: h.cfg.Issuer, Revoked: h.revoked, TokenUse: jwt.TOKEN_USE_ACCESS})
+	if err != nil {
+		h.logger.Debug("subject token rejected", "client_id", client.ClientID, "error", err)
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "subject_token is invalid, expired or revoked", http.StatusBadRequest)
+		return
+	}
+	if holder, _ := subject.Claim(claimClientID); holder != client.ClientID && !slices.Contains(subject.Audience, client.ClientID) {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "subject_token was not issued to or for this client", http.StatusBadRequest)
+		return
+	}
+	if bound, ok := jwt.ConfirmationJKT(subject); ok && bound != jkt {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "subject_token is bound to another DPoP key", http.StatusBadRequest)
+		return
+	}
+	if _, ok := jwt.ConfirmationX5T(subject); ok && jwt.VerifyCertificateBound(subject, peerCertificate(r)) != nil {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "subject_token is bound to another client certificate", http.StatusBadRequest)
+		return
+	}
+
+	audience := slices.Concat(req.Audience, req.Resource)
+	if len(audience) == 0 {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "audience or resource is required", http.StatusBadRequest)
+		return
+	}
+	for _, aud := range audience {
+		if !client.AllowsExchangeAudience(aud) {
+			logs.SecurityEvent(h.logger, "token_exchange_denied",
+				"client_id", client.ClientID,
+				"audience", aud,
+			)
+			utilities.WriteJSONError(w, utilities.InvalidTarget, "client may not exchange tokens for this audience", http.StatusBadRequest)
+			return
+		}
+	}
+
+	granted, _ := subject.Claim(jwt.SCOPE)
+	scope := granted
+	if req.Scope != "" {
+		for _, s := range strings.Fields(req.Scope) {
+			if !jwt.HasScope(granted, s) {
+				utilities.WriteJSONError(w, utilities.InvalidScope, "requested scope exceeds the subject token's scope", http.StatusBadRequest)
+				return
+			}
+		}
+		scope = req.Scope
+	}
+
+	now := time.Now()
+	expiresAt := tokenExpiry(now, h.cfg.ExchangeTTL, subject.ExpiresAt)
+	extra := maps.Clone(subject.Extra)
+	delete(extra, jwt.SCOPE)
+	if scope != "" {
+		extra[jwt.SCOPE] = scope
+	}
+	extra[claimClientID] = client.ClientID
+	extra[jwt.TOKEN_USE] = jwt.TOKEN_USE_ACCESS
+	extra[claimActor] = actor(client.ClientID, subject.Extra[claimActor])
+	delete(extra, jwt.CONFIRMATION)
+	cnf, tokenType := confirmation(r, client, jkt)
+	if cnf != nil {
+		extra[jwt.CONFIRMATION] = cnf
+	}
+
+	token, err := jwt.Mint(jwt.Payload{
+		ID:        jwt.NewID(),
+		Issuer:    h.cfg.Issuer,
+		Subject:   subject.Subject,
+		Audience:  audience,
+		ExpiresAt: expiresAt,
+		Extra:     extra,
+	})
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+
+	resp := model.TokenResponse{
+		AccessToken:     token,
+		IssuedTokenType: tokenTypeAccessToken,
+		TokenType:       tokenType,
+		ExpiresIn:       int(expiresAt.Sub(now) / time.Second),
+		Scope:           scope,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// actor builds the RFC 8693 "act" claim for clientID, nesting any earlier actor so the delegation chain
+// stays visible.
+func actor(clientID string, prior any) map[string]any {
+	act := map[string]any{jwt.SUBJECT: clientID}
+	if prior != nil {
+		act[claimActor] = prior
+	}
+	return act
+}
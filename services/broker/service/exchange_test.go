@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+)
+
+func TestTokenExchange(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	cfg := &config.BrokerConfig{Issuer: "sim-broker", ExchangeTTL: 5 * time.Minute}
+	clients := testClients(t,
+		config.Client{ClientID: "orders", GrantTypes: []string{config.GrantTokenExchange}, ExchangeAudiences: []string{"payments"}},
+		config.Client{ClientID: "other", GrantTypes: []string{config.GrantTokenExchange}, ExchangeAudiences: []string{"payments"}},
+	)
+	revoked := jwt.NewRevocationList()
+	h := NewTokenHandler(cfg, nil, clients, nil, nil, revoked, slog.New(slog.DiscardHandler))
+
+	subjectExp := time.Now().Add(time.Hour)
+	mint := func(id string, aud []string, extra map[string]any) string {
+		t.Helper()
+		token, err := jwt.Mint(jwt.Payload{
+			ID:        id,
+			Issuer:    "sim-broker",
+			Subject:   "sub-1",
+			Audience:  aud,
+			ExpiresAt: subjectExp,
+			Extra:     extra,
+		})
+		if err != nil {
+			t.Fatalf("Mint: %v", err)
+		}
+		return token
+	}
+	subject := mint("jti-1", []string{"api"}, map[string]any{
+		claimClientID:    "orders",
+		claimPhoneNumber: "+972541234567",
+		claimAMR:         []string{amrSIM},
+		jwt.SCOPE:        "openid phone",
+		jwt.TOKEN_USE:    jwt.TOKEN_USE_ACCESS,
+	})
+	addressed := mint("jti-2", []string{"orders"}, map[string]any{claimClientID: "demo-app", jwt.SCOPE: "phone"})
+	idToken := mint("jti-3", []string{"orders"}, map[string]any{claimClientID: "orders", jwt.SCOPE: "phone", jwt.TOKEN_USE: jwt.TOKEN_USE_ID})
+
+	exchange := func(clientID string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("grant_type", config.GrantTokenExchange)
+		if !form.Has("subject_token") {
+			form.Set("subject_token", subject)
+		}
+		form.Set("subject_token_type", tokenTypeAccessToken)
+		w := httptest.NewRecorder()
+		h.Handle(w, postForm(TokenPath, clientID, form))
+		return w
+	}
+
+	w := exchange("orders", url.Values{"audience": {"payments"}, "scope": {"phone"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var resp model.TokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.IssuedTokenType != tokenTypeAccessToken || resp.RefreshToken != "" || resp.ExpiresIn > 300 {
+		t.Errorf("response = %+v, want a short-lived access token without refresh", resp)
+	}
+	got, err := jwt.ValidateIssued(t.Context(), resp.AccessToken, jwt.Expected{Issuer: "sim-broker", Audience: []string{"payments"}})
+	if err != nil {
+		t.Fatalf("exchanged token invalid: %v", err)
+	}
+	if got.Subject != "sub-1" || !got.ExpiresAt.Before(subjectExp) {
+		t.Errorf("exchanged token sub=%q exp=%v, want the same subject and a shorter life", got.Subject, got.ExpiresAt)
+	}
+	for name, want := range map[string]string{claimPhoneNumber: "+972541234567", claimClientID: "orders", jwt.SCOPE: "phone"} {
+		if v, _ := got.Claim(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if act, _ := got.Extra[claimActor].(map[string]any); act["sub"] != "orders" {
+		t.Errorf("act = %v, want the exchanging client", got.Extra[claimActor])
+	}
+
+	if w := exchange("orders", url.Values{"audience": {"payments"}, "subject_token": {addressed}}); w.Code != http.StatusOK {
+		t.Errorf("token addressed to the client: status = %d, body %s, want 200", w.Code, w.Body)
+	}
+
+	cases := []struct {
+		name     string
+		clientID string
+		form     url.Values
+		want     string
+	}{
+		{"audience not allowed", "orders", url.Values{"audience": {"admin"}}, "invalid_target"},
+		{"no audience", "orders", url.Values{}, "invalid_request"},
+		{"wider scope", "orders", url.Values{"audience": {"payments"}, "scope": {"openid telco"}}, "invalid_scope"},
+		{"token not held by client", "other", url.Values{"resource": {"payments"}}, "invalid_grant"},
+		{"addressed token, unrelated client", "other", url.Values{"audience": {"payments"}, "subject_token": {addressed}}, "invalid_grant"},
+		{"ID token", "orders", url.Values{"audience": {"payments"}, "subject_token": {idToken}}, "invalid_grant"},
+	}
+	for _, c := range cases {
+		w := exchange(c.clientID, c.form)
+		var body map[string]any
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusBadRequest || body["error"] != c.want {
+			t.Errorf("%s: status %d error %v, want 400 %s", c.name, w.Code, body["error"], c.want)
+		}
+	}
+
+	revoked.RevokeID("jti-1", subjectExp)
+	if w := exchange("orders", url.Values{"audience": {"payments"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("revoked subject token status = %d, want 400", w.Code)
+	}
+}
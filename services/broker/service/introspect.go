@@ -0,0 +1,101 @@
+package service
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+const claimAuthMethod = "auth_method"
+
+type IntrospectHandler struct {
+	cfg     *config.BrokerConfig
+	clients *auth.Authenticator
+	revoked *jwt.RevocationList
+	errs    *utilities.ErrorReporter
+	logger  *slog.Logger
+}
+
+func NewIntrospectHandler(cfg *config.BrokerConfig, clients *auth.Authenticator, revoked *jwt.RevocationList, logger *slog.Logger) *IntrospectHandler {
+	return &IntrospectHandler{
+		cfg:     cfg,
+		clients: clients,
+		revoked: revoked,
+		errs:    utilities.NewErrorReporter(logger, cfg.DebugErrors),
+		logger:  logger,
+	}
+}
+
+func (h *IntrospectHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "introspection requests must use POST", http.StatusMethodNotAllowed))
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "introspection requests must be form encoded", http.StatusUnsupportedMediaType))
+		return
+	}
+
+	client, err := h.clients.Authenticate(r, assertionAudiences(h.cfg.Issuer, r, IntrospectPath))
+	if err != nil {
+		h.logger.Warn("client authentication failed", "error", err)
+		w.Header().Set("WWW-Authenticate", auth.REALM)
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidClient, "client authentication failed", http.StatusUnauthorized).Wrap(err))
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "token is required", http.StatusBadRequest))
+		return
+	}
+
+	resp := model.IntrospectionResponse{}
+	claims, err := jwt.ValidateIssued(r.Context(), token, jwt.Expected{Issuer: h.cfg.Issuer, Revoked: h.revoked, TokenUse: jwt.TOKEN_USE_ACCESS})
+	if err == nil {
+		resp = introspection(claims)
+	} else {
+		h.logger.Debug("introspected token is inactive", "client_id", client.ClientID, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func introspection(claims *jwt.Payload) model.IntrospectionResponse {
+	extra := maps.Clone(claims.Extra)
+	resp := model.IntrospectionResponse{
+		Active:    true,
+		TokenType: "Bearer",
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		ExpiresAt: claims.ExpiresAt.Unix(),
+		IssuedAt:  claims.IssuedAt.Unix(),
+		ID:        claims.ID,
+		Claims:    extra,
+	}
+	resp.ClientID, _ = claims.Claim(claimClientID)
+	if _, ok := jwt.ConfirmationJKT(claims); ok {
+		resp.TokenType = jwt.DPOP
+	}
+
+	if legacy, ok := extra[claimLegacyExtra].(map[string]any); ok {
+		resp.AuthMethod, _ = legacy[claimAuthMethod].(string)
+		delete(extra, claimLegacyExtra)
+	}
+	if amr, ok := extra[claimAMR].([]any); ok && resp.AuthMethod == "" && slices.Contains(amr, any(amrSIM)) {
+		resp.AuthMethod = amrSIM
+	}
+	return resp
+}
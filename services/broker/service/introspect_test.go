@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+func TestIntrospectHandler(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	cfg := &config.BrokerConfig{Issuer: "sim-broker"}
+	revoked := jwt.NewRevocationList()
+	h := NewIntrospectHandler(cfg, testClients(t, config.Client{ClientID: "gateway"}), revoked, slog.New(slog.DiscardHandler))
+
+	token, err := jwt.Mint(jwt.Payload{
+		ID:        "jti-1",
+		Issuer:    "sim-broker",
+		Subject:   "sub-1",
+		Audience:  []string{"api"},
+		ExpiresAt: time.Now().Add(time.Minute),
+		Extra: map[string]any{
+			claimClientID:    "demo-app",
+			claimTelco:       "partner",
+			claimAMR:         []string{amrSIM},
+			claimLegacyExtra: map[string]any{claimAuthMethod: amrSIM},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+
+	introspect := func(token string) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.Handle(w, postForm(IntrospectPath, "gateway", url.Values{"token": {token}}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var got map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return got
+	}
+
+	got := introspect(token)
+	if got["active"] != true || got["sub"] != "sub-1" || got["telco"] != "partner" ||
+		got["auth_method"] != "sim" || got["client_id"] != "demo-app" {
+		t.Errorf("introspection = %v, want an active token with its claims", got)
+	}
+	if _, ok := got["extra"]; ok {
+		t.Error("legacy extra envelope should be flattened")
+	}
+
+	if got := introspect("not-a-jwt"); len(got) != 1 || got["active"] != false {
+		t.Errorf("garbage token introspection = %v, want only active=false", got)
+	}
+
+	if got["token_type"] != "Bearer" {
+		t.Errorf("token_type = %v, want Bearer", got["token_type"])
+	}
+
+	bound, err := jwt.Mint(jwt.Payload{
+		ID:        "jti-2",
+		Issuer:    "sim-broker",
+		Subject:   "sub-1",
+		Audience:  []string{"api"},
+		ExpiresAt: time.Now().Add(time.Minute),
+		Extra:     map[string]any{jwt.CONFIRMATION: map[string]any{jwt.JWK_THUMBPRINT: "thumbprint"}},
+	})
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if got := introspect(bound); got["active"] != true || got["token_type"] != jwt.DPOP {
+		t.Errorf("DPoP-bound token introspection = %v, want active with token_type DPoP", got)
+	}
+
+	idToken, err := jwt.Mint(jwt.Payload{
+		ID:        "jti-3",
+		Issuer:    "sim-broker",
+		Subject:   "sub-1",
+		Audience:  []string{"demo-app"},
+		ExpiresAt: time.Now().Add(time.Minute),
+		Extra:     map[string]any{jwt.TOKEN_USE: jwt.TOKEN_USE_ID},
+	})
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if got := introspect(idToken); len(got) != 1 || got["active"] != false {
+		t.Errorf("ID token introspection = %v, want only active=false", got)
+	}
+
+	revoked.RevokeID("jti-1", time.Now().Add(time.Minute))
+	if got := introspect(token); got["active"] != false {
+		t.Errorf("revoked token introspection = %v, want inactive", got)
+	}
+
+	w := httptest.NewRecorder()
+	r := postForm(IntrospectPath, "gateway", url.Values{"token": {token}})
+	r.SetBasicAuth("gateway", "wrong")
+	r = r.WithContext(context.WithValue(r.Context(), utilities.CtxRequestID{}, "req-42"))
+	h.Handle(w, r)
+	var body map[string]any
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusUnauthorized || body["error"] != utilities.InvalidClient || body["error_id"] != "req-42" {
+		t.Errorf("bad credentials status = %d body %v, want 401 invalid_client with error_id", w.Code, body)
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/utils"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/logs"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+const hintRefreshToken = "refresh_token"
+
+type RevokeHandler struct {
+	cfg     *config.BrokerConfig
+	clients *auth.Authenticator
+	refresh *refresh.Manager
+	revoked *jwt.RevocationList
+	maxTTL  time.Duration
+	errs    *utilities.ErrorReporter
+	logger  *slog.Logger
+}
+
+func NewRevokeHandler(cfg *config.BrokerConfig, clients *auth.Authenticator, refresh *refresh.Manager, revoked *jwt.RevocationList, logger *slog.Logger) *RevokeHandler {
+	return &RevokeHandler{
+		cfg:     cfg,
+		clients: clients,
+		refresh: refresh,
+		revoked: revoked,
+		maxTTL:  maxAccessTTL(cfg),
+		errs:    utilities.NewErrorReporter(logger, cfg.DebugErrors),
+		logger:  logger,
+	}
+}
+
+func (h *RevokeHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "revocation requests must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "revocation requests must be form encoded", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	client, err := h.clients.Authenticate(r, assertionAudiences(h.cfg.Issuer, r, RevokePath))
+	if err != nil {
+		h.logger.Warn("client authentication failed", "error", err)
+		w.Header().Set("WWW-Authenticate", auth.REALM)
+		utilities.WriteJSONError(w, utilities.InvalidClient, "client authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	token := r.PostFormValue("token")
+	subject := r.PostFormValue("subject")
+	phone := r.PostFormValue("phone_number")
+	switch {
+	case token != "":
+		err = h.revokeToken(r, client, token, r.PostFormValue("token_type_hint"))
+	case subject != "" || phone != "":
+		if !client.RevokeSubjects {
+			utilities.WriteJSONError(w, utilities.UnauthorizedClient, "client may not revoke by subject", http.StatusBadRequest)
+			return
+		}
+		if phone != "" {
+			if phone, err = utils.NormalizePhone(phone); err != nil {
+				utilities.WriteJSONError(w, utilities.InvalidRequest, "phone_number must be in E.164 format", http.StatusBadRequest)
+				return
+			}
+		}
+		err = h.revokeSubject(client, subject, phone)
+	default:
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "token is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeToken revokes an access or refresh token issued to client. Tokens that are unknown, invalid or
+// belong to another client are ignored, as RFC 7009 requires.
+func (h *RevokeHandler) revokeToken(r *http.Request, client *auth.Client, token, hint string) error {
+	if hint == hintRefreshToken {
+		if ok, err := h.revokeRefresh(client, token); ok || err != nil {
+			return err
+		}
+		h.revokeAccess(r, client, token)
+		return nil
+	}
+	if h.revokeAccess(r, client, token) {
+		return nil
+	}
+	_, err := h.revokeRefresh(client, token)
+	return err
+}
+
+func (h *RevokeHandler) revokeAccess(r *http.Request, client *auth.Client, token string) bool {
+	claims, err := jwt.ValidateIssued(r.Context(), token, jwt.Expected{Issuer: h.cfg.Issuer, TokenUse: jwt.TOKEN_USE_ACCESS})
+	if err != nil {
+		return false
+	}
+	if owner, _ := claims.Claim(claimClientID); owner != client.ClientID {
+		return false
+	}
+	h.revoked.RevokeID(claims.ID, claims.ExpiresAt)
+	h.logger.Info("access token revoked", "client_id", client.ClientID, "jti", claims.ID)
+	return true
+}
+
+func (h *RevokeHandler) revokeRefresh(client *auth.Client, token string) (bool, error) {
+	ok, err := h.refresh.Revoke(token, client.ClientID)
+	if ok && err == nil {
+		h.logger.Info("refresh token revoked", "client_id", client.ClientID)
+	}
+	return ok, err
+}
+
+func (h *RevokeHandler) revokeSubject(client *auth.Client, subject, phone string) error {
+	until := time.Now().Add(h.maxTTL)
+	if subject != "" {
+		h.revoked.RevokeSubject(subject, until)
+	}
+	if phone != "" {
+		h.revoked.RevokePhone(phone, until)
+	}
+	logs.SecurityEvent(h.logger, "subject_revoked", "client_id", client.ClientID, "by_phone", phone != "")
+	return h.refresh.RevokeSubject(subject, phone)
+}
+
+// maxAccessTTL is the longest lifetime any access token, exchanged tokens included, can be issued with,
+// which bounds how long a subject revocation has to be remembered.
+func maxAccessTTL(cfg *config.BrokerConfig) time.Duration {
+	ttl := max(cfg.TokenTTL, cfg.ExchangeTTL)
+	for _, c := range cfg.Clients {
+		ttl = max(ttl, c.TokenTTL)
+	}
+	return ttl
+}
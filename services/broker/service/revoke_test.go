@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testClients(t *testing.T, clients ...config.Client) *auth.Authenticator {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	for i := range clients {
+		clients[i].AuthMethod = config.AuthClientSecretBasic
+		clients[i].SecretHashes = []string{string(hash)}
+		if len(clients[i].GrantTypes) == 0 {
+			clients[i].GrantTypes = []string{config.GrantAuthorizationCode, config.GrantRefreshToken}
+		}
+	}
+	a, err := auth.NewAuthenticator(clients, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return a
+}
+
+func postForm(path, clientID string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientID, "secret")
+	return r
+}
+
+func TestRevokeHandler(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	cfg := &config.BrokerConfig{Issuer: "sim-broker", TokenTTL: 15 * time.Minute}
+	clients := testClients(t, config.Client{ClientID: "demo-app"}, config.Client{ClientID: "admin", RevokeSubjects: true})
+	refreshes := refresh.NewManager(refresh.NewMemoryStore(), time.Hour)
+	revoked := jwt.NewRevocationList()
+	h := NewRevokeHandler(cfg, clients, refreshes, revoked, slog.New(slog.DiscardHandler))
+
+	access, err := jwt.Mint(jwt.Payload{
+		ID:        "jti-1",
+		Issuer:    "sim-broker",
+		Subject:   "sub-1",
+		ExpiresAt: time.Now().Add(time.Minute),
+		Extra:     map[string]any{claimClientID: "demo-app", claimPhoneNumber: "+972541234567"},
+	})
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	exp := jwt.Expected{Issuer: "sim-broker", Revoked: revoked}
+
+	w := httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "admin", url.Values{"token": {access}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke by other client status = %d, want 200", w.Code)
+	}
+	if _, err := jwt.ValidateIssued(context.Background(), access, exp); err != nil {
+		t.Fatalf("token revoked by a client it was not issued to: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "demo-app", url.Values{"token": {access}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d, want 200", w.Code)
+	}
+	if _, err := jwt.ValidateIssued(context.Background(), access, exp); !errors.Is(err, jwt.ErrRevoked) {
+		t.Errorf("revoked access token error = %v, want ErrRevoked", err)
+	}
+
+	rt, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-2"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	w = httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "demo-app", url.Values{"token": {rt}, "token_type_hint": {"refresh_token"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh revoke status = %d, want 200", w.Code)
+	}
+	if _, _, err := refreshes.Redeem(rt, "demo-app", ""); !errors.Is(err, refresh.ErrInvalidToken) {
+		t.Errorf("revoked refresh token error = %v, want ErrInvalidToken", err)
+	}
+
+	w = httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "demo-app", url.Values{"phone_number": {"+972541234567"}}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("subject revoke by ordinary client status = %d, want 400", w.Code)
+	}
+	earlier := time.Now().Add(-time.Second)
+	w = httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "admin", url.Values{"subject": {"sub-3"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("subject revoke status = %d, want 200", w.Code)
+	}
+	if !revoked.IsRevoked(&jwt.Payload{Subject: "sub-3", IssuedAt: earlier}) {
+		t.Error("subject revocation not recorded")
+	}
+
+	w = httptest.NewRecorder()
+	r := postForm(RevokePath, "demo-app", url.Values{"token": {access}})
+	r.SetBasicAuth("demo-app", "wrong")
+	h.Handle(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("bad credentials status = %d, want 401", w.Code)
+	}
+}
+
+func TestRevokeHandler_PhoneWithFilteredClaims(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	const phone = "+972541234567"
+	cfg := &config.BrokerConfig{Issuer: "sim-broker", TokenTTL: 15 * time.Minute, ExchangeTTL: time.Hour}
+	refreshes := refresh.NewManager(refresh.NewMemoryStore(), time.Hour)
+	revoked := jwt.NewRevocationList()
+	h := NewRevokeHandler(cfg, testClients(t, config.Client{ClientID: "admin", RevokeSubjects: true}), refreshes, revoked, slog.New(slog.DiscardHandler))
+	if h.maxTTL != time.Hour {
+		t.Errorf("maxTTL = %s, want the exchange TTL", h.maxTTL)
+	}
+
+	rt, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", Phone: phone, Claims: map[string]any{claimAMR: []string{amrSIM}}})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	revoked.BindPhone(phone, "sub-1", time.Now().Add(time.Minute))
+	access := &jwt.Payload{Subject: "sub-1", IssuedAt: time.Now().Add(-time.Second), Extra: map[string]any{claimAMR: []string{amrSIM}}}
+
+	w := httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "admin", url.Values{"phone_number": {phone}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("phone revoke status = %d, body %s", w.Code, w.Body)
+	}
+	if !revoked.IsRevoked(access) {
+		t.Error("access token without phone_number survived phone revocation")
+	}
+	if _, _, err := refreshes.Redeem(rt, "demo-app", ""); !errors.Is(err, refresh.ErrInvalidToken) {
+		t.Errorf("refresh family without phone_number claim error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRevokeHandler_SubjectAndPhone(t *testing.T) {
+	const phone = "+972541234567"
+	cfg := &config.BrokerConfig{Issuer: "sim-broker", TokenTTL: 15 * time.Minute}
+	refreshes := refresh.NewManager(refresh.NewMemoryStore(), time.Hour)
+	revoked := jwt.NewRevocationList()
+	h := NewRevokeHandler(cfg, testClients(t, config.Client{ClientID: "admin", RevokeSubjects: true}), refreshes, revoked, slog.New(slog.DiscardHandler))
+
+	bySubject, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", Phone: "+972549999999"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	byPhone, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-2", Phone: phone})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	earlier := time.Now().Add(-time.Second)
+
+	w := httptest.NewRecorder()
+	h.Handle(w, postForm(RevokePath, "admin", url.Values{"subject": {"sub-1"}, "phone_number": {phone}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d, body %s", w.Code, w.Body)
+	}
+	if !revoked.IsRevoked(&jwt.Payload{Subject: "sub-1", IssuedAt: earlier}) {
+		t.Error("access token for the subject survived")
+	}
+	if !revoked.IsRevoked(&jwt.Payload{Subject: "sub-2", IssuedAt: earlier, Extra: map[string]any{claimPhoneNumber: phone}}) {
+		t.Error("access token for the phone under another subject survived")
+	}
+	for name, rt := range map[string]string{"subject": bySubject, "phone": byPhone} {
+		if _, _, err := refreshes.Redeem(rt, "demo-app", ""); !errors.Is(err, refresh.ErrInvalidToken) {
+			t.Errorf("refresh family for the %s error = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
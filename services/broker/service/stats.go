@@ -0,0 +1,34 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+// StatsPath serves operator statistics. It is only mounted on the admin listener, never the public one.
+const StatsPath = "/debug/jwks"
+
+type StatsHandler struct {
+	telcos *clients.Registry
+}
+
+func NewStatsHandler(telcos *clients.Registry) *StatsHandler {
+	return &StatsHandler{telcos: telcos}
+}
+
+func (h *StatsHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "stats requests must use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := struct {
+		JWKS []clients.JWKSStats `json:"jwks"`
+	}{
+		JWKS: h.telcos.Stats(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -1,97 +1,483 @@
 package service
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/authz"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/utils"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/logs"
 	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
 )
 
 type TokenHandler struct {
-	cfg    *config.BrokerConfig
-	logger *slog.Logger
+	cfg      *config.BrokerConfig
+	upstream upstream
+	clients  *auth.Authenticator
+	refresh  *refresh.Manager
+	codes    *authz.Store[authz.Code]
+	revoked  *jwt.RevocationList
+	dpop     *auth.ReplayCache
+	errs     *utilities.ErrorReporter
+	logger   *slog.Logger
 }
 
-func NewTokenHandler(cfg *config.BrokerConfig, logger *slog.Logger) *TokenHandler {
-	return &TokenHandler{cfg: cfg, logger: logger}
+func NewTokenHandler(cfg *config.BrokerConfig, telcos *clients.Registry, clients *auth.Authenticator, refresh *refresh.Manager, codes *authz.Store[authz.Code], revoked *jwt.RevocationList, logger *slog.Logger) *TokenHandler {
+	return &TokenHandler{
+		cfg:      cfg,
+		upstream: upstream{cfg: cfg, telcos: telcos, logger: logger},
+		clients:  clients,
+		refresh:  refresh,
+		codes:    codes,
+		revoked:  revoked,
+		dpop:     auth.NewReplayCache(),
+		errs:     utilities.NewErrorReporter(logger, cfg.DebugErrors),
+		logger:   logger,
+	}
 }
 
 func (h *TokenHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		utilities.WriteJSONError(w, "method not allowed", r.Method, http.StatusMethodNotAllowed)
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "token requests must use POST", http.StatusMethodNotAllowed)
 		return
 	}
 	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
-		utilities.WriteJSONError(w, "unsupported media type", ct, http.StatusUnsupportedMediaType)
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "token requests must be form encoded", http.StatusUnsupportedMediaType)
 		return
 	}
 
 	req, err := model.Parse(r)
 	if err != nil {
-		utilities.WriteJSONError(w, "invalid_form", err.Error(), http.StatusBadRequest)
+		h.errs.Write(w, r, errMalformedForm.Wrap(err))
+		return
+	}
+
+	client, err := h.clients.Authenticate(r, assertionAudiences(h.cfg.Issuer, r, TokenPath))
+	if err != nil {
+		h.logger.Warn("client authentication failed", "error", err)
+		w.Header().Set("WWW-Authenticate", auth.REALM)
+		utilities.WriteJSONError(w, utilities.InvalidClient, "client authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.GrantType {
+	case config.GrantAuthorizationCode, config.GrantRefreshToken, config.GrantTokenExchange:
+	default:
+		utilities.WriteJSONError(w, utilities.UnsupportedGrantType, "only authorization_code, refresh_token and token-exchange are supported", http.StatusBadRequest)
 		return
 	}
-	if req.GrantType != "authorization_code" {
-		utilities.WriteJSONError(w, "unsupported_grant_type", "only authorization_code is supported", http.StatusBadRequest)
+	if !client.AllowsGrant(req.GrantType) {
+		utilities.WriteJSONError(w, utilities.UnauthorizedClient, "client may not use this grant type", http.StatusBadRequest)
 		return
 	}
 
-	if !utils.IsValidE164(req.Phone) {
-		utilities.WriteJSONError(w, "invalid phone number format", "only E.164 phone numbers are supported", http.StatusBadRequest)
+	jkt, err := h.dpopKey(r)
+	if err != nil {
+		h.logger.Warn("DPoP proof rejected", "client_id", client.ClientID, "error", err)
+		utilities.WriteJSONError(w, utilities.InvalidDPoPProof, "DPoP proof is invalid", http.StatusBadRequest)
 		return
 	}
 
-	telcoCfg, err := utils.MatchPrefix(req.Phone, h.cfg.PrefixMap)
+	switch req.GrantType {
+	case config.GrantAuthorizationCode:
+		h.authorizationCode(w, r, client, req, jkt)
+	case config.GrantRefreshToken:
+		h.refreshToken(w, r, client, req, jkt)
+	case config.GrantTokenExchange:
+		h.tokenExchange(w, r, client, req, jkt)
+	}
+}
+
+// dpopKey verifies the request's DPoP proof, if any, and returns the thumbprint of its key.
+func (h *TokenHandler) dpopKey(r *http.Request) (string, error) {
+	proofs := r.Header.Values(jwt.DPOP)
+	switch len(proofs) {
+	case 0:
+		return "", nil
+	case 1:
+	default:
+		return "", errors.New("more than one DPoP header")
+	}
+	proof, err := jwt.VerifyDPoP(proofs[0], r.Method, publicBaseURL(h.cfg.Issuer, r)+TokenPath, "", jwt.DPoPOptions{Replay: h.dpop})
+	if err != nil {
+		return "", err
+	}
+	return proof.JKT, nil
+}
+
+func (h *TokenHandler) authorizationCode(w http.ResponseWriter, r *http.Request, client *auth.Client, req model.TokenRequest, jkt string) {
+	if code, ok := h.codes.Take(req.Code); ok {
+		code.Grant.JKT = jkt
+		h.brokerCode(w, r, client, req, code)
+		return
+	}
+
+	if !client.AllowsRedirect(req.RedirectURI) {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	phone, err := utils.NormalizePhone(req.Phone)
+	if err != nil {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "only E.164 phone numbers are supported", http.StatusBadRequest)
+		return
+	}
+
+	telcoCfg, tel, err := h.upstream.telcos.Resolve(phone)
+	if errors.Is(err, clients.ErrNotConfigured) {
+		h.errs.Write(w, r, errNotConfigured.Wrap(err))
+		return
+	}
 	if err != nil {
-		utilities.WriteJSONError(w, "invalid phone number", err.Error(), http.StatusBadRequest)
+		h.errs.Write(w, r, errNoOperator.Wrap(err))
 		return
 	}
+	logs.Annotate(r, slog.Any("telco", telcoCfg))
 
-	tel := clients.New(telcoCfg)
 	form := url.Values{
 		"grant_type":    {req.GrantType},
 		"code":          {req.Code},
 		"redirect_uri":  {req.RedirectURI},
 		"code_verifier": {req.CodeVerifier},
+		"scope":         {req.Scope},
 	}
-	access, err := tel.ExchangeCode(r.Context(), form)
-	if err != nil {
-		utilities.WriteJSONError(w, "unable to retrive", err.Error(), http.StatusBadGateway)
+	grant, notAfter, gerr := h.upstream.exchangeWith(r.Context(), client, telcoCfg, tel, phone, form)
+	if gerr != nil {
+		h.errs.Write(w, r, gerr)
+		return
+	}
+	grant.JKT = jkt
+	h.issue(w, r, client, grant, notAfter, "", "")
+}
+
+// brokerCode redeems a code issued by /authorize, which is bound to the client, its redirect_uri and
+// its PKCE challenge.
+func (h *TokenHandler) brokerCode(w http.ResponseWriter, r *http.Request, client *auth.Client, req model.TokenRequest, code authz.Code) {
+	if code.ClientID != client.ClientID {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "code was issued to another client", http.StatusBadRequest)
+		return
+	}
+	if code.RedirectURI != req.RedirectURI {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "redirect_uri does not match the authorization request", http.StatusBadRequest)
+		return
+	}
+	if !authz.VerifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "code_verifier does not match the code_challenge", http.StatusBadRequest)
 		return
 	}
+	h.issue(w, r, client, code.Grant, code.NotAfter, "", code.Nonce)
+}
+
+var (
+	errMalformedForm  = utilities.NewOAuthError(utilities.InvalidRequest, "request body is not a valid form", http.StatusBadRequest)
+	errNoOperator     = utilities.NewOAuthError(utilities.InvalidRequest, "no operator serves this phone number", http.StatusBadRequest)
+	errInvalidRefresh = utilities.NewOAuthError(utilities.InvalidGrant, "refresh token is invalid, expired or bound to another client", http.StatusBadRequest)
+	errNotConfigured  = utilities.NewOAuthError(utilities.ServerError, "operator is not configured", http.StatusInternalServerError)
+)
+
+// upstream redeems telco authorization codes and turns the verified telco token into a broker grant.
+type upstream struct {
+	cfg    *config.BrokerConfig
+	telcos *clients.Registry
+	logger *slog.Logger
+}
 
-	claims, err := jwt.Validate(r.Context(), access, tel, telcoCfg.BaseURL+"/.well-known/jwks.json")
+// exchange redeems the telco code in form with the registry's current client for telcoCfg.
+func (u upstream) exchange(ctx context.Context, client *auth.Client, telcoCfg config.Telco, phone string, form url.Values) (refresh.Token, time.Time, *utilities.OAuthError) {
+	tel, err := u.telcos.Get(telcoCfg)
 	if err != nil {
-		utilities.WriteJSONError(w, "invalid token from telco", err.Error(), http.StatusBadGateway)
+		return refresh.Token{}, time.Time{}, errNotConfigured.Wrap(err)
+	}
+	return u.exchangeWith(ctx, client, telcoCfg, tel, phone, form)
+}
+
+// exchangeWith is exchange through tel, a client resolved together with telcoCfg.
+func (u upstream) exchangeWith(ctx context.Context, client *auth.Client, telcoCfg config.Telco, tel *clients.TelcoClient, phone string, form url.Values) (refresh.Token, time.Time, *utilities.OAuthError) {
+	scope := form.Get(jwt.SCOPE)
+	form.Del(jwt.SCOPE)
+	if jwt.HasScope(scope, jwt.SCOPE_OPENID) {
+		form.Set(jwt.SCOPE, jwt.SCOPE_OPENID)
+	}
+	tokens, err := tel.ExchangeCode(ctx, form)
+	if err != nil {
+		var oe *utilities.OAuthError
+		if !errors.As(err, &oe) {
+			oe = &utilities.OAuthError{Code: utilities.ServerError, Description: "operator could not be reached", Status: http.StatusBadGateway, Err: err}
+		}
+		return refresh.Token{}, time.Time{}, oe
+	}
+
+	claims, err := jwt.Validate(ctx, tokens.AccessToken, tel.JWKS(), jwt.Expected{
+		Issuer:     telcoCfg.Issuer,
+		Audience:   telcoCfg.Audience,
+		Leeway:     telcoCfg.Leeway,
+		Algorithms: telcoCfg.Algorithms,
+	})
+	if err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) {
+			u.logger.Warn("telco token rejected", "telco", telcoCfg, "check", verr.Check, "error", err)
+			return refresh.Token{}, time.Time{}, &utilities.OAuthError{Code: utilities.InvalidGrant, Description: "telco token failed validation", Status: http.StatusBadRequest, Err: err}
+		}
+		return refresh.Token{}, time.Time{}, &utilities.OAuthError{Code: utilities.ServerError, Description: "operator returned an unusable token", Status: http.StatusBadGateway, Err: err}
+	}
+
+	if tokens.IDToken != "" {
+		if gerr := u.checkIDToken(ctx, tel, telcoCfg, tokens.IDToken, claims.Subject); gerr != nil {
+			return refresh.Token{}, time.Time{}, gerr
+		}
+	}
+
+	if !phoneMatches(claims, telcoCfg.SubjectClaim, phone) {
+		logs.SecurityEvent(u.logger, "phone_mismatch",
+			"telco", telcoCfg,
+			"client_id", client.ClientID,
+			"claim", telcoCfg.SubjectClaim,
+		)
+		return refresh.Token{}, time.Time{}, utilities.NewOAuthError(utilities.InvalidGrant, "authenticated subscriber does not match the requested phone", http.StatusBadRequest)
+	}
+
+	verified := verifiedClaims(telcoCfg, phone, claims)
+	grant := refresh.Token{
+		ClientID:  client.ClientID,
+		Subject:   claims.Subject,
+		Phone:     phone,
+		Audience:  tokenAudience(u.cfg.Audience, client, telcoCfg),
+		Claims:    simClaims(client, verified),
+		AccessTTL: accessTTL(u.cfg.TokenTTL, client.TokenTTL, telcoCfg.TokenTTL),
+	}
+	if jwt.HasScope(scope, jwt.SCOPE_OPENID) {
+		grant.Scope = scope
+		grant.IDClaims = pickClaims(verified, idTokenClaims)
+	}
+	return grant, claims.ExpiresAt, nil
+}
+
+// checkIDToken validates an ID token the telco returned next to its access token: it must be signed by
+// the telco, issued to the broker and name the same subject.
+func (u upstream) checkIDToken(ctx context.Context, tel *clients.TelcoClient, telcoCfg config.Telco, idToken, subject string) *utilities.OAuthError {
+	claims, err := jwt.Validate(ctx, idToken, tel.JWKS(), jwt.Expected{
+		Issuer:     telcoCfg.Issuer,
+		Audience:   []string{telcoCfg.ClientID},
+		Leeway:     telcoCfg.Leeway,
+		Algorithms: telcoCfg.Algorithms,
+	})
+	if err == nil && claims.Subject != subject {
+		err = errors.New("id_token subject does not match the access token")
+	}
+	if err != nil {
+		u.logger.Warn("telco id_token rejected", "telco", telcoCfg, "error", err)
+		return &utilities.OAuthError{Code: utilities.InvalidGrant, Description: "telco id_token is invalid", Status: http.StatusBadRequest, Err: err}
+	}
+	return nil
+}
+
+func (h *TokenHandler) refreshToken(w http.ResponseWriter, r *http.Request, client *auth.Client, req model.TokenRequest, jkt string) {
+	if req.RefreshToken == "" {
+		utilities.WriteJSONError(w, utilities.InvalidRequest, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	grant, next, err := h.refresh.Redeem(req.RefreshToken, client.ClientID, jkt)
+	if errors.Is(err, refresh.ErrReused) {
+		logs.SecurityEvent(h.logger, "refresh_token_reuse",
+			"client_id", client.ClientID,
+			"family_id", grant.FamilyID,
+		)
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "refresh token was already used", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, refresh.ErrInvalidToken) {
+		h.errs.Write(w, r, errInvalidRefresh.Wrap(err))
 		return
 	}
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	if grant.JKT == "" {
+		grant.JKT = jkt
+	}
+	h.issue(w, r, client, grant, grant.NotAfter, next, "")
+}
 
+// issue mints an access token for grant, an ID token when the grant has the openid scope and, for
+// clients allowed the refresh_token grant, a refresh token. notAfter caps the access token's expiry when
+// non-zero and is kept on a new refresh family, so tokens refreshed later stay within the telco token's
+// lifetime; refreshToken is the already-rotated successor on a refresh, or empty to start a new family.
+func (h *TokenHandler) issue(w http.ResponseWriter, r *http.Request, client *auth.Client, grant refresh.Token, notAfter time.Time, refreshToken, nonce string) {
+	now := time.Now()
+	expiresAt := tokenExpiry(now, grant.AccessTTL, notAfter)
+	if !expiresAt.After(now) {
+		utilities.WriteJSONError(w, utilities.InvalidGrant, "telco token has expired", http.StatusBadRequest)
+		return
+	}
+
+	extra := map[string]any{claimClientID: client.ClientID}
+	maps.Copy(extra, grant.Claims)
+	extra[jwt.TOKEN_USE] = jwt.TOKEN_USE_ACCESS
+	if grant.Scope != "" {
+		extra[jwt.SCOPE] = grant.Scope
+	}
+	cnf, tokenType := confirmation(r, client, grant.JKT)
+	if cnf != nil {
+		extra[jwt.CONFIRMATION] = cnf
+	}
 	outToken, err := jwt.Mint(jwt.Payload{
-		Issuer:    "sim-broker",
-		Subject:   claims.Subject,
-		Audience:  claims.Audience,
-		ExpiresAt: time.Now().Add(15 * time.Minute),
-		Extra:     map[string]any{"auth_method": "sim"},
+		ID:        jwt.NewID(),
+		Issuer:    h.cfg.Issuer,
+		Subject:   grant.Subject,
+		Audience:  grant.Audience,
+		ExpiresAt: expiresAt,
+		Extra:     extra,
 	})
 	if err != nil {
-		utilities.WriteJSONError(w, "cannot mint token", err.Error(), http.StatusInternalServerError)
+		h.errs.Write(w, r, err)
 		return
 	}
+	if grant.Phone != "" {
+		h.revoked.BindPhone(grant.Phone, grant.Subject, expiresAt)
+	}
+
+	var idToken string
+	if jwt.HasScope(grant.Scope, jwt.SCOPE_OPENID) {
+		idToken, err = h.idToken(client, grant, outToken, expiresAt, nonce)
+		if err != nil {
+			h.errs.Write(w, r, err)
+			return
+		}
+	}
+
+	if refreshToken == "" && client.AllowsGrant(config.GrantRefreshToken) {
+		grant.NotAfter = notAfter
+		refreshToken, err = h.refresh.Issue(grant)
+		if err != nil {
+			h.errs.Write(w, r, err)
+			return
+		}
+	}
 
 	resp := model.TokenResponse{
-		AccessToken: outToken,
-		TokenType:   "bearer",
-		ExpiresIn:   900,
+		AccessToken:  outToken,
+		TokenType:    tokenType,
+		ExpiresIn:    int(expiresAt.Sub(now) / time.Second),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        grant.Scope,
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// confirmation builds the "cnf" claim that binds a token to the request's DPoP key (jkt) and, for
+// clients that authenticated with mutual TLS, to their certificate. It also returns the token_type.
+func confirmation(r *http.Request, client *auth.Client, jkt string) (map[string]any, string) {
+	cnf := map[string]any{}
+	tokenType := "bearer"
+	if jkt != "" {
+		cnf[jwt.JWK_THUMBPRINT] = jkt
+		tokenType = jwt.DPOP
+	}
+	if cert := peerCertificate(r); cert != nil && client.UsesMTLS() {
+		cnf[jwt.X5T_S256] = jwt.CertificateThumbprint(cert)
+	}
+	if len(cnf) == 0 {
+		return nil, tokenType
+	}
+	return cnf, tokenType
+}
+
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// idToken mints the OIDC ID token for the client, bound to accessToken through at_hash. Its token_use
+// keeps it from being accepted anywhere an access token is expected.
+func (h *TokenHandler) idToken(client *auth.Client, grant refresh.Token, accessToken string, expiresAt time.Time, nonce string) (string, error) {
+	atHash, err := jwt.AtHash(accessToken)
+	if err != nil {
+		return "", err
+	}
+	extra := maps.Clone(grant.IDClaims)
+	if extra == nil {
+		extra = map[string]any{}
+	}
+	extra[jwt.AT_HASH] = atHash
+	extra[jwt.TOKEN_USE] = jwt.TOKEN_USE_ID
+	if nonce != "" {
+		extra[jwt.NONCE] = nonce
+	}
+	return jwt.Mint(jwt.Payload{
+		ID:        jwt.NewID(),
+		Issuer:    h.cfg.Issuer,
+		Subject:   grant.Subject,
+		Audience:  []string{client.ClientID},
+		ExpiresAt: expiresAt,
+		Extra:     extra,
+	})
+}
+
+// assertionAudiences lists the "aud" values a private_key_jwt assertion may carry for the endpoint at path.
+func assertionAudiences(issuer string, r *http.Request, path string) []string {
+	return []string{issuer, publicBaseURL(issuer, r) + path}
+}
+
+// tokenAudience picks the most specific configured audience: client, then telco, then broker default.
+func tokenAudience(defaults []string, client *auth.Client, telco config.Telco) []string {
+	switch {
+	case len(client.Audience) > 0:
+		return client.Audience
+	case len(telco.TokenAudience) > 0:
+		return telco.TokenAudience
+	case len(defaults) > 0:
+		return defaults
+	}
+	return []string{client.ClientID}
+}
+
+// accessTTL applies the client's TTL (or the broker default), capped by the telco's TTL.
+func accessTTL(defaultTTL, clientTTL, telcoTTL time.Duration) time.Duration {
+	ttl := defaultTTL
+	if clientTTL > 0 {
+		ttl = clientTTL
+	}
+	if telcoTTL > 0 && telcoTTL < ttl {
+		ttl = telcoTTL
+	}
+	return ttl
+}
+
+// tokenExpiry caps now+ttl at notAfter (the upstream token's expiry) so the broker token never outlives it.
+func tokenExpiry(now time.Time, ttl time.Duration, notAfter time.Time) time.Time {
+	exp := now.Add(ttl)
+	if !notAfter.IsZero() && notAfter.Before(exp) {
+		exp = notAfter
+	}
+	return exp
+}
+
+func phoneMatches(claims *jwt.Payload, claim, phone string) bool {
+	verified, ok := claims.Claim(claim)
+	if !ok {
+		return false
+	}
+	normalized, err := utils.NormalizePhone(verified)
+	return err == nil && normalized == phone
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/authz"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/model"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/refresh"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/logs"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	far := now.Add(time.Hour)
+
+	cases := []struct {
+		name               string
+		def, client, telco time.Duration
+		notAfter           time.Time
+		want               time.Duration
+	}{
+		{"default", 15 * time.Minute, 0, 0, far, 15 * time.Minute},
+		{"client override", 15 * time.Minute, 30 * time.Minute, 0, far, 30 * time.Minute},
+		{"telco caps client", 15 * time.Minute, 30 * time.Minute, 5 * time.Minute, far, 5 * time.Minute},
+		{"telco longer than default", 15 * time.Minute, 0, 20 * time.Minute, far, 15 * time.Minute},
+		{"upstream caps", 15 * time.Minute, 0, 0, now.Add(2 * time.Minute), 2 * time.Minute},
+		{"no upstream cap", 15 * time.Minute, 0, 0, time.Time{}, 15 * time.Minute},
+	}
+	for _, tc := range cases {
+		got := tokenExpiry(now, accessTTL(tc.def, tc.client, tc.telco), tc.notAfter)
+		if got.Sub(now) != tc.want {
+			t.Errorf("%s: ttl = %v, want %v", tc.name, got.Sub(now), tc.want)
+		}
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	client := &auth.Client{Client: config.Client{ClientID: "demo-app"}}
+	telco := config.Telco{}
+
+	if got := tokenAudience(nil, client, telco); !slices.Equal(got, []string{"demo-app"}) {
+		t.Errorf("fallback audience = %v, want client_id", got)
+	}
+	if got := tokenAudience([]string{"api"}, client, telco); !slices.Equal(got, []string{"api"}) {
+		t.Errorf("broker default audience = %v, want [api]", got)
+	}
+	telco.TokenAudience = []string{"telco-api"}
+	if got := tokenAudience([]string{"api"}, client, telco); !slices.Equal(got, []string{"telco-api"}) {
+		t.Errorf("telco audience = %v, want [telco-api]", got)
+	}
+	client.Audience = []string{"client-api"}
+	if got := tokenAudience([]string{"api"}, client, telco); !slices.Equal(got, []string{"client-api"}) {
+		t.Errorf("client audience = %v, want [client-api]", got)
+	}
+}
+
+// mockTelcoCode logs phone in at the mock telco's /authorize and returns the code it issues.
+func mockTelcoCode(t *testing.T, telcoURL, phone, verifier string) string {
+	t.Helper()
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(telcoURL + "/authorize?" + url.Values{
+		"client_id":             {"telco-client"},
+		"redirect_uri":          {clientRedirect},
+		"login_hint":            {phone},
+		"code_challenge":        {authz.S256(verifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode())
+	if err != nil {
+		t.Fatalf("telco authorize: %v", err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || back.Query().Get("code") == "" {
+		t.Fatalf("telco authorize redirected to %q, want a code", resp.Header.Get("Location"))
+	}
+	return back.Query().Get("code")
+}
+
+func TestAuthorizationCode_PhoneBinding(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := jwt.InitKeyring([]jwt.SigningKey{{KeyID: "k1", Algorithm: string(jose.ES256), Key: priv}}); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", jwt.AuthorizeHandler("telco-client"))
+	mux.HandleFunc("/token", jwt.JWTsHandler("telco-client", "telco-secret", ""))
+	mux.HandleFunc("/.well-known/jwks.json", jwt.JWKsHandler)
+	telcoSrv := httptest.NewServer(mux)
+	defer telcoSrv.Close()
+
+	cfg := &config.BrokerConfig{
+		Issuer:   "sim-broker",
+		TokenTTL: 15 * time.Minute,
+		PrefixMap: map[string]config.Telco{"97254": {
+			BaseURL:      telcoSrv.URL,
+			ClientID:     "telco-client",
+			ClientSecret: "telco-secret",
+			Leeway:       30 * time.Second,
+			SubjectClaim: jwt.PHONE_NUMBER,
+			ACR:          config.DefaultACR,
+		}},
+	}
+	var logged bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logged, nil))
+	registry := clients.NewRegistry(cfg.PrefixMap, logger)
+	defer registry.Close()
+	authn := testClients(t, config.Client{ClientID: "demo-app", RedirectURIs: []string{clientRedirect}})
+	token := NewTokenHandler(cfg, registry, authn, refresh.NewManager(refresh.NewMemoryStore(), time.Hour), authz.NewStore[authz.Code](authz.CodeTTL), jwt.NewRevocationList(), logger)
+
+	redeem := func(code, phone, verifier string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		token.Handle(w, postForm(TokenPath, "demo-app", url.Values{
+			"grant_type":    {config.GrantAuthorizationCode},
+			"code":          {code},
+			"phone":         {phone},
+			"redirect_uri":  {clientRedirect},
+			"code_verifier": {verifier},
+		}))
+		return w
+	}
+
+	verifier := authz.NewVerifier()
+	if w := redeem(mockTelcoCode(t, telcoSrv.URL, "+972541234567", verifier), "+972541234567", verifier); w.Code != http.StatusOK {
+		t.Fatalf("matching phone status = %d, body %s", w.Code, w.Body)
+	}
+
+	logged.Reset()
+	w := redeem(mockTelcoCode(t, telcoSrv.URL, "+972549999999", verifier), "+972541234567", verifier)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), utilities.InvalidGrant) {
+		t.Fatalf("mismatched phone status = %d, body %s; want 400 invalid_grant", w.Code, w.Body)
+	}
+	if !strings.Contains(logged.String(), `"`+logs.SecurityEventKey+`":"phone_mismatch"`) {
+		t.Errorf("no phone_mismatch security event in logs: %s", logged.String())
+	}
+}
+
+func TestRefreshTokenGrant_RotationAndReuse(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	var logged bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logged, nil))
+	refreshes := refresh.NewManager(refresh.NewMemoryStore(), time.Hour)
+	cfg := &config.BrokerConfig{Issuer: "sim-broker", TokenTTL: 15 * time.Minute}
+	token := NewTokenHandler(cfg, nil, testClients(t, config.Client{ClientID: "demo-app"}), refreshes, nil, jwt.NewRevocationList(), logger)
+
+	redeem := func(rt string) (*httptest.ResponseRecorder, model.TokenResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		token.Handle(w, postForm(TokenPath, "demo-app", url.Values{"grant_type": {config.GrantRefreshToken}, "refresh_token": {rt}}))
+		var resp model.TokenResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return w, resp
+	}
+	wantInvalidGrant := func(name string, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), utilities.InvalidGrant) {
+			t.Errorf("%s: status = %d, body %s; want 400 invalid_grant", name, w.Code, w.Body)
+		}
+	}
+
+	first, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", AccessTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	w, rotated := redeem(first)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first redeem status = %d, body %s", w.Code, w.Body)
+	}
+	if rotated.AccessToken == "" || rotated.RefreshToken == "" || rotated.RefreshToken == first {
+		t.Fatalf("first redeem = %+v, want an access token and a new refresh token", rotated)
+	}
+
+	w, next := redeem(rotated.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotated token status = %d, body %s", w.Code, w.Body)
+	}
+
+	logged.Reset()
+	w, _ = redeem(first)
+	wantInvalidGrant("reused token", w)
+	if !strings.Contains(logged.String(), `"`+logs.SecurityEventKey+`":"refresh_token_reuse"`) {
+		t.Errorf("no refresh_token_reuse security event in logs: %s", logged.String())
+	}
+
+	w, _ = redeem(next.RefreshToken)
+	wantInvalidGrant("latest token after reuse", w)
+}
+
+func TestRefreshTokenGrant_KeepsUpstreamExpiry(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	refreshes := refresh.NewManager(refresh.NewMemoryStore(), time.Hour)
+	cfg := &config.BrokerConfig{Issuer: "sim-broker", TokenTTL: 15 * time.Minute}
+	token := NewTokenHandler(cfg, nil, testClients(t, config.Client{ClientID: "demo-app"}), refreshes, nil, jwt.NewRevocationList(), slog.New(slog.DiscardHandler))
+	redeem := func(rt string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		token.Handle(w, postForm(TokenPath, "demo-app", url.Values{"grant_type": {config.GrantRefreshToken}, "refresh_token": {rt}}))
+		return w
+	}
+
+	rt, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", AccessTTL: 15 * time.Minute, NotAfter: time.Now().Add(2 * time.Minute)})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	w := redeem(rt)
+	var resp model.TokenResponse
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&resp) != nil {
+		t.Fatalf("redeem status = %d, body %s", w.Code, w.Body)
+	}
+	if resp.ExpiresIn > 120 {
+		t.Errorf("expires_in = %d, want at most the telco token's remaining 120s", resp.ExpiresIn)
+	}
+
+	expired, err := refreshes.Issue(refresh.Token{ClientID: "demo-app", Subject: "sub-1", AccessTTL: 15 * time.Minute, NotAfter: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if w := redeem(expired); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), utilities.InvalidGrant) {
+		t.Errorf("refresh after the telco token expired: status = %d, body %s; want 400 invalid_grant", w.Code, w.Body)
+	}
+}
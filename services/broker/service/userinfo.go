@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/auth"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/router"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+const (
+	scopePhone = "phone"
+	scopeTelco = "telco"
+)
+
+type UserinfoHandler struct {
+	cfg     *config.BrokerConfig
+	telcos  *clients.Registry
+	revoked *jwt.RevocationList
+	dpop    *auth.ReplayCache
+	errs    *utilities.ErrorReporter
+	logger  *slog.Logger
+}
+
+func NewUserinfoHandler(cfg *config.BrokerConfig, telcos *clients.Registry, revoked *jwt.RevocationList, logger *slog.Logger) *UserinfoHandler {
+	return &UserinfoHandler{
+		cfg:     cfg,
+		telcos:  telcos,
+		revoked: revoked,
+		dpop:    auth.NewReplayCache(),
+		errs:    utilities.NewErrorReporter(logger, cfg.DebugErrors),
+		logger:  logger,
+	}
+}
+
+// Handle returns the subscriber's claims for a broker access token, limited to the scopes it was granted.
+func (h *UserinfoHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "userinfo requests must use GET or POST", http.StatusMethodNotAllowed))
+		return
+	}
+
+	token, scheme, ok := accessToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer, DPoP`)
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidRequest, "an access token is required", http.StatusUnauthorized))
+		return
+	}
+	claims, err := jwt.ValidateIssued(r.Context(), token, jwt.Expected{Issuer: h.cfg.Issuer, Revoked: h.revoked, TokenUse: jwt.TOKEN_USE_ACCESS})
+	if err == nil {
+		err = h.checkBinding(r, claims, token, scheme)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", scheme+` error="invalid_token"`)
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InvalidToken, "access token is invalid, expired or revoked", http.StatusUnauthorized).Wrap(err))
+		return
+	}
+	scope, _ := claims.Claim(jwt.SCOPE)
+	if !jwt.HasScope(scope, jwt.SCOPE_OPENID) {
+		w.Header().Set("WWW-Authenticate", scheme+` error="insufficient_scope", scope="openid"`)
+		h.errs.Write(w, r, utilities.NewOAuthError(utilities.InsufficientScope, "access token was not granted the openid scope", http.StatusForbidden))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(userinfo(claims, scope, h.telcos.Routes()))
+}
+
+// checkBinding requires certificate-bound tokens to arrive over a connection with the same client
+// certificate, DPoP-bound tokens to arrive under the DPoP scheme with a proof from the bound key, and
+// other tokens to arrive as Bearer.
+func (h *UserinfoHandler) checkBinding(r *http.Request, claims *jwt.Payload, token, scheme string) error {
+	if _, ok := jwt.ConfirmationX5T(claims); ok {
+		if err := jwt.VerifyCertificateBound(claims, peerCertificate(r)); err != nil {
+			return err
+		}
+	}
+	_, bound := jwt.ConfirmationJKT(claims)
+	switch {
+	case bound && scheme == jwt.DPOP:
+		uri := publicBaseURL(h.cfg.Issuer, r) + UserinfoPath
+		return jwt.VerifyDPoPBound(claims, token, r.Header.Get(jwt.DPOP), r.Method, uri, jwt.DPoPOptions{Replay: h.dpop})
+	case bound:
+		return errors.New("DPoP-bound token presented as a bearer token")
+	case scheme == jwt.DPOP:
+		return errors.New("bearer token presented under the DPoP scheme")
+	}
+	return nil
+}
+
+// userinfo builds the response from the token's claims; telco details come from the current routes.
+func userinfo(claims *jwt.Payload, scope string, routes *router.Router) map[string]any {
+	out := map[string]any{jwt.SUBJECT: claims.Subject}
+	phone, hasPhone := claims.Claim(claimPhoneNumber)
+
+	if jwt.HasScope(scope, scopePhone) && hasPhone {
+		out[claimPhoneNumber] = phone
+		out[claimPhoneNumberVerified] = true
+	}
+	if jwt.HasScope(scope, scopeTelco) && hasPhone {
+		if telco, err := routes.Match(phone); err == nil {
+			for name, v := range map[string]string{
+				claimTelco:   telco.Name,
+				claimMCC:     telco.MCC,
+				claimMNC:     telco.MNC,
+				claimCountry: telco.Country,
+			} {
+				if v != "" {
+					out[name] = v
+				}
+			}
+		}
+	}
+	return out
+}
+
+// accessToken reads the access token and its scheme (Bearer or DPoP) from the Authorization header or,
+// for POST, an RFC 6750 form body.
+func accessToken(r *http.Request) (string, string, bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok {
+		token = strings.TrimSpace(token)
+		switch {
+		case strings.EqualFold(scheme, "Bearer"):
+			return token, "Bearer", token != ""
+		case strings.EqualFold(scheme, jwt.DPOP):
+			return token, jwt.DPOP, token != ""
+		}
+	}
+	if r.Method == http.MethodPost {
+		if token := r.PostFormValue("access_token"); token != "" {
+			return token, "Bearer", true
+		}
+	}
+	return "", "", false
+}
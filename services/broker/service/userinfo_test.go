@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Forty-SixNTwo/sim-auth-token-broker-broker/clients"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/jwt"
+	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/utilities"
+)
+
+func TestUserinfoHandler(t *testing.T) {
+	if err := jwt.InitHS256([]byte("test-secret-test-secret-test-sec")); err != nil {
+		t.Fatalf("InitHS256: %v", err)
+	}
+	cfg := &config.BrokerConfig{
+		Issuer: "sim-broker",
+		PrefixMap: map[string]config.Telco{
+			"97254": {Name: "partner", MCC: "425", MNC: "01", Country: "IL"},
+		},
+	}
+	revoked := jwt.NewRevocationList()
+	telcos := clients.NewRegistry(cfg.PrefixMap, slog.New(slog.DiscardHandler))
+	defer telcos.Close()
+	h := NewUserinfoHandler(cfg, telcos, revoked, slog.New(slog.DiscardHandler))
+
+	mint := func(id, scope string) string {
+		t.Helper()
+		token, err := jwt.Mint(jwt.Payload{
+			ID:        id,
+			Issuer:    "sim-broker",
+			Subject:   "sub-1",
+			ExpiresAt: time.Now().Add(time.Minute),
+			Extra:     map[string]any{jwt.SCOPE: scope, claimPhoneNumber: "+972541234567"},
+		})
+		if err != nil {
+			t.Fatalf("Mint: %v", err)
+		}
+		return token
+	}
+	call := func(token string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, UserinfoPath, nil)
+		r = r.WithContext(context.WithValue(r.Context(), utilities.CtxRequestID{}, "req-42"))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.Handle(w, r)
+		var got map[string]any
+		json.NewDecoder(w.Body).Decode(&got)
+		return w, got
+	}
+
+	w, got := call(mint("jti-1", "openid phone telco"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got["sub"] != "sub-1" || got["phone_number"] != "+972541234567" || got["phone_number_verified"] != true ||
+		got["telco"] != "partner" || got["mcc"] != "425" || got["mnc"] != "01" || got["country"] != "IL" {
+		t.Errorf("userinfo = %v, want phone and telco claims", got)
+	}
+
+	if _, got := call(mint("jti-2", "openid")); len(got) != 1 || got["sub"] != "sub-1" {
+		t.Errorf("openid-only userinfo = %v, want only sub", got)
+	}
+	if w, _ := call(mint("jti-3", "phone")); w.Code != http.StatusForbidden {
+		t.Errorf("token without openid status = %d, want 403", w.Code)
+	}
+	if w, got := call(""); w.Code != http.StatusUnauthorized || got["error_id"] != "req-42" || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("missing token status = %d body %v, want 401 with error_id and a challenge", w.Code, got)
+	}
+	if w, got := call("not-a-jwt"); got["error"] != utilities.InvalidToken || got["error_id"] != "req-42" ||
+		w.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+		t.Errorf("invalid token body %v challenge %q, want invalid_token with error_id", got, w.Header().Get("WWW-Authenticate"))
+	}
+
+	idToken, err := jwt.Mint(jwt.Payload{
+		ID:        "jti-4",
+		Issuer:    "sim-broker",
+		Subject:   "sub-1",
+		Audience:  []string{"demo-app"},
+		ExpiresAt: time.Now().Add(time.Minute),
+		Extra:     map[string]any{jwt.SCOPE: "openid phone", jwt.TOKEN_USE: jwt.TOKEN_USE_ID},
+	})
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if w, _ := call(idToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("ID token status = %d, want 401", w.Code)
+	}
+
+	revoked.RevokeID("jti-1", time.Now().Add(time.Minute))
+	if w, _ := call(mint("jti-1", "openid phone")); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("revoked token status = %d, want 401 with a challenge", w.Code)
+	}
+}
@@ -1,10 +1,6 @@
 package utils
 
-import (
-	"testing"
-
-	"github.com/Forty-SixNTwo/sim-auth-token-broker/libs/config"
-)
+import "testing"
 
 func TestIsValidE164(t *testing.T) {
 	cases := []struct {
@@ -26,32 +22,27 @@ func TestIsValidE164(t *testing.T) {
 	}
 }
 
-func TestMatchPrefix(t *testing.T) {
-	prefixMap := map[string]config.Telco{
-		"97205":  {BaseURL: "orange"},
-		"972050": {BaseURL: "vodafone"},
-		"4477":   {BaseURL: "vf-uk"},
-	}
-
+func TestNormalizePhone(t *testing.T) {
 	cases := []struct {
-		phone   string
-		wantURL string
+		input   string
+		want    string
 		wantErr bool
 	}{
-		{"+97205012345", "vodafone", false},
-		{"+97205123456", "orange", false},
-		{"4477123456", "vf-uk", false},
-		{"12345", "", true},
+		{"+972541234567", "+972541234567", false},
+		{"972541234567", "+972541234567", false},
+		{" tel:+972541234567 ", "+972541234567", false},
+		{"+97254-123-4567", "", true},
+		{"", "", true},
 	}
 
 	for _, c := range cases {
-		telco, err := MatchPrefix(c.phone, prefixMap)
+		got, err := NormalizePhone(c.input)
 		if (err != nil) != c.wantErr {
-			t.Errorf("MatchPrefix(%q) error = %v, wantErr %v", c.phone, err, c.wantErr)
+			t.Errorf("NormalizePhone(%q) error = %v, wantErr %v", c.input, err, c.wantErr)
 			continue
 		}
-		if err == nil && telco.BaseURL != c.wantURL {
-			t.Errorf("MatchPrefix(%q).BaseURL = %q, want %q", c.phone, telco.BaseURL, c.wantURL)
+		if got != c.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", c.input, got, c.want)
 		}
 	}
 }
@@ -38,6 +38,12 @@ func main() {
 			http.HandlerFunc(jwt.JWKsHandler),
 		),
 	)
+	mux.Handle(
+		"/authorize",
+		logs.LoggingMiddleware(logger)(
+			http.HandlerFunc(jwt.AuthorizeHandler(cfg.TelcoClientID)),
+		),
+	)
 	mux.Handle(
 		"/token",
 		logs.LoggingMiddleware(logger)(